@@ -0,0 +1,65 @@
+package httpdshutdown
+
+import (
+	"net/http"
+	"testing"
+)
+
+// connStateCycle is what a connection serving one request reports.
+var connStateCycle = []http.ConnState{http.StateNew, http.StateActive, http.StateIdle, http.StateClosed}
+
+func BenchmarkRecordConnState(b *testing.B) {
+	w, _ := NewWatcher(1000)
+	w.Accepting(true)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for _, state := range connStateCycle {
+				w.RecordConnState(state)
+			}
+		}
+	})
+}
+
+// BenchmarkRecordConnStateMutex is the baseline for BenchmarkRecordConnState: it
+// counts the same transitions the way the slow path does, under the mutex.
+func BenchmarkRecordConnStateMutex(b *testing.B) {
+	w, _ := NewWatcher(1000)
+	w.Accepting(true)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for _, state := range connStateCycle {
+				w.mu.Lock()
+				switch state {
+				case http.StateNew:
+					w.connOpenedLocked()
+				case http.StateClosed:
+					w.connClosedLocked()
+				}
+				w.unlockAndNotify()
+			}
+		}
+	})
+}
+
+func TestRecordConnStateFastPath(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestRecordConnStateFastPath: should not be nil")
+	}
+	w.Accepting(true)
+	if !w.fastPath.Load() {
+		t.Errorf("TestRecordConnStateFastPath: should count without the lock while accepting")
+	}
+	w.RecordConnState(http.StateNew)
+	w.RecordConnState(http.StateNew)
+	w.RecordConnState(http.StateClosed)
+	w.RecordConnState(http.StateClosed)
+	w.RecordConnState(http.StateClosed) // unbalanced
+	if w.ActiveConns() != 0 || w.PeakConns() != 2 || w.Validate() == nil {
+		t.Errorf("TestRecordConnStateFastPath: should count like the slow path")
+	}
+	w.Accepting(false)
+	if w.fastPath.Load() {
+		t.Errorf("TestRecordConnStateFastPath: should take the lock while rejecting")
+	}
+}
@@ -0,0 +1,96 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// AddChild makes `OnStop` on w also stop child, for applications whose subsystems
+// each have their own Watcher. When w's drain begins, every child's `OnStop` is
+// started concurrently with w's grace period as its timeout, and w waits for them
+// all before running its own hooks. Their errors are reported in the
+// `ChildErrors` of w's `ShutdownError`. With `NoTimeout`, each child keeps its own
+// timeout. Cancelling w's drain, with `Cancel` or `Close`, cancels the children's
+// drains too, so w returns promptly rather than waiting out their grace periods. A
+// child that already has w among its descendants is rejected, as the cycle would
+// stop forever.
+//
+// Example use:
+//
+//	err := app.AddChild(queueWatcher)
+func (w *Watcher) AddChild(child *Watcher) error {
+	if w == nil {
+		return errors.New("AddChild: receiver is nil")
+	}
+	if child == nil {
+		return errors.New("AddChild: child is nil")
+	}
+	// Serialized so two concurrent calls cannot each close half of a cycle.
+	childrenMu.Lock()
+	defer childrenMu.Unlock()
+	if child.descendsTo(w) {
+		// Stopping a cycle would recurse forever.
+		return errors.New("AddChild: child would make a cycle")
+	}
+	w.mu.Lock()
+	w.children = append(w.children, child)
+	w.mu.Unlock()
+	return nil
+}
+
+// childrenMu guards changes to the graph of Watchers built by `AddChild`.
+var childrenMu sync.Mutex
+
+// descendsTo reports whether target is w or one of its descendants.
+func (w *Watcher) descendsTo(target *Watcher) bool {
+	seen := make(map[*Watcher]bool)
+	pending := []*Watcher{w}
+	for len(pending) != 0 {
+		next := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if next == target {
+			return true
+		}
+		if seen[next] {
+			continue
+		}
+		seen[next] = true
+		next.mu.Lock()
+		pending = append(pending, next.children...)
+		next.mu.Unlock()
+	}
+	return false
+}
+
+// stopChildren starts stopping the children, each with the given timeout, or their
+// own if it is zero. Closing cancel cancels their drains. The returned channel
+// receives their errors once they have all stopped.
+func (w *Watcher) stopChildren(ctx context.Context, timeout time.Duration, cancel <-chan struct{}) <-chan []error {
+	w.mu.Lock()
+	children := make([]*Watcher, len(w.children))
+	copy(children, w.children)
+	w.mu.Unlock()
+	errs := make([]error, len(children))
+	stopped := make(chan []error, 1)
+	go func() {
+		var wg sync.WaitGroup
+		for i, child := range children {
+			wg.Add(1)
+			go func(i int, child *Watcher) {
+				defer wg.Done()
+				errs[i] = child.stop(stopConfig{ctx: ctx, timeout: timeout, cancel: cancel})
+			}(i, child)
+		}
+		wg.Wait()
+		failed := make([]error, 0)
+		for _, err := range errs {
+			if err != nil {
+				failed = append(failed, err)
+			}
+		}
+		stopped <- failed
+	}()
+	return stopped
+}
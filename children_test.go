@@ -0,0 +1,116 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAddChild(t *testing.T) {
+	parent, wErr := NewWatcher(3000)
+	if parent == nil || wErr != nil {
+		t.Fatalf("TestAddChild: should not be nil")
+	}
+	childErr := errors.New("child hook failed")
+	drained, failing := make([]*Watcher, 2), make([]*Watcher, 2)
+	for i := range drained {
+		drained[i], _ = NewWatcher(5000)
+		drained[i].RecordConn(true)
+		go func(w *Watcher) {
+			time.Sleep(50 * time.Millisecond)
+			w.RecordConn(false)
+		}(drained[i])
+	}
+	failing[0], _ = NewWatcher(5000, func() error { return childErr })
+	var clock *fakeClock
+	failing[1], clock = newFakeClockWatcher(t, 5000)
+	failing[1].RecordConn(true) // never closed, so times out with the parent
+	for _, child := range append(drained, failing...) {
+		err := parent.AddChild(child)
+		if err != nil {
+			t.Fatalf("TestAddChild: should not have error")
+		}
+	}
+	if parent.AddChild(parent) == nil || parent.AddChild(nil) == nil {
+		t.Errorf("TestAddChild: should reject itself and nil")
+	}
+	grandchild, _ := NewWatcher(5000)
+	_ = drained[0].AddChild(grandchild)
+	if drained[0].AddChild(parent) == nil || grandchild.AddChild(parent) == nil {
+		t.Errorf("TestAddChild: should reject a cycle")
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- parent.OnStop()
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(3 * time.Second)
+	var err error
+	select {
+	case err = <-errChan:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("TestAddChild: children should share the parent's timeout")
+	}
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) || len(shutdownErr.ChildErrors) != 2 {
+		t.Fatalf("TestAddChild: should aggregate two child errors, got %v", err)
+	}
+	if shutdownErr.TimedOut {
+		t.Errorf("TestAddChild: parent itself should not time out")
+	}
+	if !errors.Is(err, childErr) {
+		t.Errorf("TestAddChild: should match the child hook error")
+	}
+	var childShutdownErr *ShutdownError
+	if !errors.As(shutdownErr.ChildErrors[1], &childShutdownErr) || !childShutdownErr.TimedOut {
+		t.Errorf("TestAddChild: hanging child should time out, got %v", shutdownErr.ChildErrors[1])
+	}
+	for _, child := range append(drained, failing...) {
+		if !child.IsShuttingDown() {
+			t.Errorf("TestAddChild: every child should be stopped")
+		}
+	}
+	for _, child := range drained {
+		if child.ActiveConns() != 0 {
+			t.Errorf("TestAddChild: children should drain")
+		}
+	}
+}
+
+func TestCancelChildren(t *testing.T) {
+	for _, name := range []string{"Cancel", "Close"} {
+		parent, wErr := NewWatcher(30000)
+		if parent == nil || wErr != nil {
+			t.Fatalf("TestCancelChildren: should not be nil")
+		}
+		child, _ := NewWatcher(NoTimeout)
+		child.RecordConn(true) // never closed, so only a cancel ends its drain
+		_ = parent.AddChild(child)
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- parent.OnStop()
+		}()
+		for !child.IsShuttingDown() {
+			time.Sleep(time.Millisecond)
+		}
+		if name == "Cancel" {
+			_ = parent.Cancel()
+		} else {
+			go func() { _ = parent.Close() }()
+		}
+		var err error
+		select {
+		case err = <-errChan:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("TestCancelChildren: %s should return promptly with a busy child", name)
+		}
+		var shutdownErr *ShutdownError
+		if !errors.As(err, &shutdownErr) || len(shutdownErr.ChildErrors) != 1 {
+			t.Fatalf("TestCancelChildren: %s should report the child, got %v", name, err)
+		}
+		if !errors.Is(shutdownErr.ChildErrors[0], ErrCancelled) {
+			t.Errorf("TestCancelChildren: %s should cancel the child, got %v", name, shutdownErr.ChildErrors[0])
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"time"
+)
+
+// Clock is the source of time used by a Watcher. It exists so tests can control the
+// passage of time instead of sleeping; see `WithClock`.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the default Clock, backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// WithClock replaces the Watcher's source of time. This is intended for tests that
+// want to trigger timeouts deterministically.
+func WithClock(c Clock) Option {
+	return func(w *Watcher) error {
+		if c == nil {
+			return errors.New("WithClock: clock is nil")
+		}
+		w.clock = c
+		return nil
+	}
+}
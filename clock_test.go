@@ -0,0 +1,123 @@
+package httpdshutdown
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when Advance is called.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := make(chan time.Time, 1)
+	f.waiters = append(f.waiters, fakeWaiter{deadline: f.now.Add(d), c: c})
+	return c
+}
+
+// Advance moves the clock forward, firing any waiters whose deadline has passed.
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	pending := f.waiters[:0]
+	for _, waiter := range f.waiters {
+		if f.now.Before(waiter.deadline) {
+			pending = append(pending, waiter)
+			continue
+		}
+		waiter.c <- f.now
+	}
+	f.waiters = pending
+}
+
+// BlockUntil waits for n callers to be waiting on the clock.
+func (f *fakeClock) BlockUntil(n int) {
+	for {
+		f.mu.Lock()
+		waiting := len(f.waiters)
+		f.mu.Unlock()
+		if waiting >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func newFakeClockWatcher(t *testing.T, timeoutMS int, hooks ...ShutdownHook) (*Watcher, *fakeClock) {
+	w, wErr := NewWatcher(timeoutMS, hooks...)
+	if w == nil || wErr != nil {
+		t.Fatalf("newFakeClockWatcher: should not be nil")
+	}
+	clock := newFakeClock()
+	err := w.Configure(WithClock(clock))
+	if err != nil {
+		t.Fatalf("newFakeClockWatcher: should not have error")
+	}
+	return w, clock
+}
+
+func TestFakeClockTimeout(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 30000, sampleShutdownHook)
+	w.RecordConn(true) // never closed
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(29999 * time.Millisecond)
+	select {
+	case <-errChan:
+		t.Fatalf("TestFakeClockTimeout: should not time out early")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(time.Millisecond)
+	err := <-errChan
+	if err == nil {
+		t.Errorf("TestFakeClockTimeout: should have timeout error")
+	}
+}
+
+func TestFakeClockNoTimeout(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 30000)
+	w.RecordConn(true)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	w.RecordConn(false)
+	err := <-errChan
+	if err != nil {
+		t.Errorf("TestFakeClockNoTimeout: should not have error")
+	}
+}
+
+func TestWithClockNil(t *testing.T) {
+	w, _ := NewWatcher(1000)
+	err := w.Configure(WithClock(nil))
+	if err == nil {
+		t.Errorf("TestWithClockNil: should have error")
+	}
+}
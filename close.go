@@ -0,0 +1,41 @@
+package httpdshutdown
+
+import "errors"
+
+// Close releases the Watcher's background resources so it can be discarded without
+// a full shutdown, which is common in tests. An in-progress `OnStop` is aborted as
+// if by `Cancel`, and Close waits for it to return so the goroutines it started have
+// been told to exit. Once closed, `OnStop` returns an error. Close may be called more
+// than once.
+func (w *Watcher) Close() error {
+	if w == nil {
+		return errors.New("Close: receiver is nil")
+	}
+	w.mu.Lock()
+	if w.closeChan == nil {
+		w.closeChan = make(chan struct{})
+	}
+	if !w.closed {
+		w.closed = true
+		close(w.closeChan)
+	}
+	stopDone := w.stopDone
+	w.mu.Unlock()
+	if stopDone == nil {
+		// No drain is coming to close these.
+		w.endDrainUpdates()
+	}
+	if stopDone != nil {
+		<-stopDone
+	}
+	return nil
+}
+
+// closedChanLocked returns a channel that is closed once `Close` is called. w.mu
+// must be held.
+func (w *Watcher) closedChanLocked() <-chan struct{} {
+	if w.closeChan == nil {
+		w.closeChan = make(chan struct{})
+	}
+	return w.closeChan
+}
@@ -0,0 +1,57 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	w, wErr := NewWatcher(60000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestClose: should not be nil")
+	}
+	progress := make(chan int64, 1)
+	err := w.Configure(WithDrainProgress(time.Millisecond, func(remaining int64) {
+		select {
+		case progress <- remaining:
+		default:
+		}
+	}))
+	if err != nil {
+		t.Fatalf("TestClose: %v", err)
+	}
+	w.RecordConn(true)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	<-progress
+	err = w.Close()
+	if err != nil {
+		t.Errorf("TestClose: should not have error")
+	}
+	err = <-errChan
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) || !shutdownErr.Cancelled {
+		t.Errorf("TestClose: in-progress OnStop should be cancelled, got %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Errorf("TestClose: second Close should not have error")
+	}
+	if w.OnStop() == nil {
+		t.Errorf("TestClose: OnStop should fail once closed")
+	}
+
+	// Give exiting goroutines a moment to be reaped.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("TestClose: leaked %d goroutines", after-before)
+	}
+}
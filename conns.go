@@ -0,0 +1,233 @@
+package httpdshutdown
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// connIDKey is the context key under which `ConnContext` stores a connection's ID.
+type connIDKey struct{}
+
+// connKey is the context key under which `ConnContext` stores the connection itself.
+type connKey struct{}
+
+// ConnContext tags each connection with an ID so the Watcher can report connections
+// that never closed; see `LeakedConns`. It also stores the connection itself, so
+// `TriggerShutdownRequest` can find it. It can be assigned to an `http.Server`'s
+// `ConnContext` field, and must be paired with `RecordConnStateConn` as the server's
+// `ConnState`, which is what forgets each connection once it closes. Paired with
+// `RecordConnState`, which does not see the connection, every connection ever
+// tagged would be kept and reported as leaked. Handlers can read the ID with
+// `ConnID`.
+//
+// Example use:
+//
+//	srv := &http.Server{
+//	        Addr:        ":8080",
+//	        ConnContext: watcher.ConnContext,
+//	        ConnState:   watcher.RecordConnStateConn,
+//	}
+func (w *Watcher) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if w == nil {
+		nilReceiver("ConnContext")
+		return ctx
+	}
+	w.mu.Lock()
+	id := w.connIDLocked(c)
+	w.mu.Unlock()
+	return context.WithValue(context.WithValue(ctx, connIDKey{}, id), connKey{}, c)
+}
+
+// ConnID returns the connection ID stored in ctx by `ConnContext`.
+func ConnID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(connIDKey{}).(string)
+	return id, ok
+}
+
+// connInfo is what the Watcher knows about a tracked connection.
+type connInfo struct {
+	id        string
+	opened    time.Time
+	idle      bool           // Last recorded in StateIdle.
+	closeIdle bool           // Close once idle, see TriggerShutdownRequest.
+	counted   bool           // Included in activeConns.
+	state     http.ConnState // Last recorded state, if stateSeen.
+	stateSeen bool           // A state has been recorded, not just an ID assigned.
+}
+
+// connIDLocked returns the ID for c, assigning one and noting when c was opened if c
+// is not yet tracked. w.mu must be held.
+func (w *Watcher) connIDLocked(c net.Conn) string {
+	if info, ok := w.conns[c]; ok {
+		return info.id
+	}
+	if w.conns == nil {
+		w.conns = make(map[net.Conn]connInfo)
+	}
+	w.connSeq++
+	id := fmt.Sprintf("%d/%s", w.connSeq, c.RemoteAddr())
+	w.conns[c] = connInfo{id: id, opened: w.clock.Now()}
+	return id
+}
+
+// RecordConnStateConn is `RecordConnState` for callers that also want connections
+// tracked individually, and broken down by kind in `ActiveConnsByKind`. Its signature
+// matches an `http.Server`'s `ConnState` field.
+func (w *Watcher) RecordConnStateConn(c net.Conn, newState http.ConnState) {
+	if w == nil {
+		nilReceiver("RecordConnStateConn")
+		return
+	}
+	closeIdle := false
+	defer func() {
+		// Closed once the lock is released; the server then records StateClosed.
+		if closeIdle {
+			_ = c.Close()
+		}
+	}()
+	w.mu.Lock()
+	defer w.unlockAndNotify()
+	defer w.logConnStateLocked("RecordConnStateConn", newState)
+	if w.strictStates {
+		w.checkTransitionLocked(c, newState)
+	}
+	switch newState {
+	case http.StateNew:
+		if w.rejecting {
+			// Still tracked and counted, as the server will serve it.
+			w.rejectedConns++
+		}
+		w.connIDLocked(c)
+		info := w.conns[c]
+		info.state, info.stateSeen = newState, true
+		w.conns[c] = info
+		w.startExpiryLocked()
+		if w.connDeltaLocked(newState) == ConnOpen {
+			w.countConnLocked(c)
+		}
+	case http.StateActive, http.StateIdle:
+		if info, ok := w.conns[c]; ok {
+			info.idle = newState == http.StateIdle
+			info.state, info.stateSeen = newState, true
+			w.conns[c] = info
+			switch w.connDeltaLocked(newState) {
+			case ConnOpen:
+				w.countConnLocked(c)
+			case ConnDone:
+				w.uncountConnLocked(c)
+			}
+		}
+		closeIdle = newState == http.StateIdle && (w.closeIdle && w.draining || w.expiredLocked(c) || w.conns[c].closeIdle)
+	case http.StateClosed, http.StateHijacked:
+		if info, ok := w.conns[c]; ok && info.counted && newState == http.StateHijacked && w.trackHijacked {
+			// Counted until ReleaseHijacked, as the handler now owns the conn.
+			info.idle = false
+			info.state, info.stateSeen = newState, true
+			w.conns[c] = info
+			return
+		}
+		w.dropConnLocked(c)
+	}
+}
+
+// countConnLocked counts the tracked conn c as open, unless it already is. w.mu must
+// be held.
+func (w *Watcher) countConnLocked(c net.Conn) {
+	info := w.conns[c]
+	if info.counted {
+		return
+	}
+	info.counted = true
+	w.conns[c] = info
+	if _, isTLS := c.(*tls.Conn); isTLS {
+		w.tlsConns++
+	}
+	w.addConnLocked()
+}
+
+// uncountConnLocked counts the tracked conn c as closed, if it is counted as open.
+// w.mu must be held.
+func (w *Watcher) uncountConnLocked(c net.Conn) {
+	info := w.conns[c]
+	if !info.counted {
+		return
+	}
+	info.counted = false
+	w.conns[c] = info
+	if _, isTLS := c.(*tls.Conn); isTLS && w.tlsConns > 0 {
+		w.tlsConns--
+	}
+	w.removeConnLocked()
+}
+
+// dropConnLocked stops tracking c and counts it as closed. A conn that is not
+// tracked is assumed to have been counted elsewhere. w.mu must be held.
+func (w *Watcher) dropConnLocked(c net.Conn) {
+	if _, ok := w.conns[c]; !ok {
+		w.removeConnLocked()
+		return
+	}
+	w.uncountConnLocked(c)
+	delete(w.conns, c)
+}
+
+// LeakedConns returns the IDs of tracked connections that are still open, sorted.
+// Called after a drain has timed out, it shows which connections held it up.
+func (w *Watcher) LeakedConns() []string {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ids := make([]string, 0, len(w.conns))
+	for _, info := range w.conns {
+		ids = append(ids, info.id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// OldestConnAge returns how long the oldest tracked connection that is still open
+// has been open, or zero if there are none. Called during a drain, it shows how long
+// the slowest connections have been holding it up. Only connections recorded with
+// `RecordConnStateConn` are tracked.
+func (w *Watcher) OldestConnAge() time.Duration {
+	if w == nil {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var oldest time.Time
+	for _, info := range w.conns {
+		if oldest.IsZero() || info.opened.Before(oldest) {
+			oldest = info.opened
+		}
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+	return w.clock.Now().Sub(oldest)
+}
+
+// ConnsByKind breaks down active connections by whether they use TLS.
+type ConnsByKind struct {
+	TLS       int64 `json:"tls"`
+	Plaintext int64 `json:"plaintext"`
+}
+
+// ActiveConnsByKind returns the number of open connections that use TLS and the
+// number that do not. Only connections recorded with `RecordConnStateConn` can be
+// identified as TLS; all others are counted as plaintext.
+func (w *Watcher) ActiveConnsByKind() ConnsByKind {
+	if w == nil {
+		return ConnsByKind{}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return ConnsByKind{TLS: w.tlsConns, Plaintext: w.activeConns.Load() - w.tlsConns}
+}
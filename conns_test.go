@@ -0,0 +1,189 @@
+package httpdshutdown
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLeakedConns(t *testing.T) {
+	w, wErr := NewWatcher(200)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestLeakedConns: should not be nil")
+	}
+	idChan := make(chan string, 1)
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		id, _ := ConnID(r.Context())
+		idChan <- id
+		fmt.Fprintln(rw, "Hello, client")
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(handler))
+	ts.Config.ConnContext = w.ConnContext
+	ts.Config.ConnState = w.RecordConnStateConn
+	ts.Start()
+	defer ts.Close()
+
+	// A keep-alive connection that is never closed.
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("TestLeakedConns: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	id := <-idChan
+	if !strings.HasSuffix(id, conn.LocalAddr().String()) {
+		t.Errorf("TestLeakedConns: unexpected conn ID %q", id)
+	}
+
+	err = w.OnStop()
+	if err == nil {
+		t.Errorf("TestLeakedConns: drain should time out")
+	}
+	leaked := w.LeakedConns()
+	if len(leaked) != 1 || leaked[0] != id {
+		t.Errorf("TestLeakedConns: should report %q as leaked, got %v", id, leaked)
+	}
+}
+
+func TestLeakedConnsClosed(t *testing.T) {
+	w, _ := NewWatcher(1000)
+	server, client := net.Pipe()
+	defer client.Close()
+	w.RecordConnStateConn(server, http.StateNew)
+	if len(w.LeakedConns()) != 1 {
+		t.Errorf("TestLeakedConnsClosed: conn should be tracked")
+	}
+	w.RecordConnStateConn(server, http.StateClosed)
+	if len(w.LeakedConns()) != 0 {
+		t.Errorf("TestLeakedConnsClosed: closed conn should not be tracked")
+	}
+	err := w.OnStop()
+	if err != nil {
+		t.Errorf("TestLeakedConnsClosed: should not have error: %v", err)
+	}
+}
+
+func TestActiveConnsByKind(t *testing.T) {
+	w, _ := NewWatcher(1000)
+	plain, plainPeer := net.Pipe()
+	defer plainPeer.Close()
+	rawTLS, rawTLSPeer := net.Pipe()
+	defer rawTLSPeer.Close()
+	secure := tls.Client(rawTLS, &tls.Config{InsecureSkipVerify: true})
+
+	w.RecordConnStateConn(plain, http.StateNew)
+	w.RecordConnStateConn(secure, http.StateNew)
+	w.RecordConnStateConn(secure, http.StateActive)
+	kinds := w.ActiveConnsByKind()
+	if kinds.TLS != 1 || kinds.Plaintext != 1 {
+		t.Errorf("TestActiveConnsByKind: should have 1 of each, got %+v", kinds)
+	}
+	w.RecordConnStateConn(secure, http.StateClosed)
+	kinds = w.ActiveConnsByKind()
+	if kinds.TLS != 0 || kinds.Plaintext != 1 {
+		t.Errorf("TestActiveConnsByKind: should have 1 plaintext, got %+v", kinds)
+	}
+	w.RecordConnStateConn(plain, http.StateHijacked)
+	kinds = w.ActiveConnsByKind()
+	if kinds.TLS != 0 || kinds.Plaintext != 0 {
+		t.Errorf("TestActiveConnsByKind: should have none, got %+v", kinds)
+	}
+}
+
+func TestRejectedConnsTracked(t *testing.T) {
+	w, _ := NewWatcher(1000)
+	early, earlyPeer := net.Pipe()
+	defer earlyPeer.Close()
+	late, latePeer := net.Pipe()
+	defer latePeer.Close()
+
+	w.Accepting(true)
+	w.RecordConnStateConn(early, http.StateNew)
+	w.Accepting(false)
+	w.RecordConnStateConn(late, http.StateNew)
+	if w.ActiveConns() != 2 || w.RejectedConns() != 1 {
+		t.Errorf("TestRejectedConnsTracked: should count 2 and reject 1, got %d and %d",
+			w.ActiveConns(), w.RejectedConns())
+	}
+	if leaked := w.LeakedConns(); len(leaked) != 2 {
+		t.Errorf("TestRejectedConnsTracked: both conns should be tracked, got %v", leaked)
+	}
+	w.RecordConnStateConn(late, http.StateClosed)
+	w.RecordConnStateConn(early, http.StateClosed)
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestRejectedConnsTracked: should have 0 active conns")
+	}
+}
+
+func TestRejectedConnsDrain(t *testing.T) {
+	for _, conn := range []bool{false, true} {
+		w, _ := NewWatcher(NoTimeout)
+		late, latePeer := net.Pipe()
+		w.Accepting(true)
+		w.RecordConn(true)
+		go func() {
+			_ = w.OnStop()
+		}()
+		for !w.IsShuttingDown() {
+			time.Sleep(time.Millisecond)
+		}
+		if conn {
+			w.RecordConnStateConn(late, http.StateNew)
+		} else {
+			w.RecordConnState(http.StateNew)
+		}
+		if w.RejectedConns() != 1 {
+			t.Errorf("TestRejectedConnsDrain: should reject the late conn")
+		}
+		w.RecordConn(false)
+		select {
+		case <-w.Done():
+			t.Errorf("TestRejectedConnsDrain: drain should wait for the late conn")
+		case <-time.After(50 * time.Millisecond):
+		}
+		if conn {
+			w.RecordConnStateConn(late, http.StateClosed)
+		} else {
+			w.RecordConnState(http.StateClosed)
+		}
+		select {
+		case <-w.Done():
+		case <-time.After(time.Second):
+			t.Errorf("TestRejectedConnsDrain: drain should end once the late conn closes")
+		}
+		_ = latePeer.Close()
+	}
+}
+
+func TestOldestConnAge(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 1000)
+	if w.OldestConnAge() != 0 {
+		t.Errorf("TestOldestConnAge: should be zero with no conns")
+	}
+	conns := make([]net.Conn, 3)
+	for i := range conns {
+		c, peer := net.Pipe()
+		defer c.Close()
+		defer peer.Close()
+		conns[i] = c
+		w.RecordConnStateConn(c, http.StateNew)
+		clock.Advance(time.Second)
+	}
+	if age := w.OldestConnAge(); age != 3*time.Second {
+		t.Errorf("TestOldestConnAge: should be 3s, got %v", age)
+	}
+	w.RecordConnStateConn(conns[0], http.StateClosed)
+	if age := w.OldestConnAge(); age != 2*time.Second {
+		t.Errorf("TestOldestConnAge: should be 2s once the oldest closes, got %v", age)
+	}
+	w.RecordConnStateConn(conns[1], http.StateClosed)
+	w.RecordConnStateConn(conns[2], http.StateClosed)
+	if w.OldestConnAge() != 0 {
+		t.Errorf("TestOldestConnAge: should be zero once all conns close")
+	}
+}
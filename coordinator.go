@@ -0,0 +1,36 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+)
+
+// DrainCoordinator serializes drains across the nodes of a cluster, typically
+// backed by a distributed lock, so that only one node drains at a time during a
+// rolling restart.
+type DrainCoordinator interface {
+	// AcquireDrainSlot blocks until this node may drain, or returns an error if it
+	// cannot. It is passed the context given to `OnStopContext`.
+	AcquireDrainSlot(ctx context.Context) error
+	// ReleaseDrainSlot lets the next node drain.
+	ReleaseDrainSlot()
+}
+
+// WithDrainCoordinator makes `OnStop` acquire a drain slot from c before it does
+// anything else, including running pre-drain hooks, and release it once the drain
+// and hooks are done. If the slot cannot be acquired `OnStop` returns the error
+// without draining, so c should bound how long it waits. By default there is no
+// coordination.
+//
+// Example use:
+//
+//	err := watcher.Configure(httpdshutdown.WithDrainCoordinator(etcdLock))
+func WithDrainCoordinator(c DrainCoordinator) Option {
+	return func(w *Watcher) error {
+		if c == nil {
+			return errors.New("WithDrainCoordinator: coordinator is nil")
+		}
+		w.coordinator = c
+		return nil
+	}
+}
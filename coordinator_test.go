@@ -0,0 +1,90 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCoordinator records the calls made to it, along with the Watcher's state.
+type fakeCoordinator struct {
+	mu         sync.Mutex
+	w          *Watcher
+	events     []string
+	acquireErr error
+}
+
+func (c *fakeCoordinator) record(event string) {
+	c.mu.Lock()
+	c.events = append(c.events, event)
+	c.mu.Unlock()
+}
+
+func (c *fakeCoordinator) AcquireDrainSlot(ctx context.Context) error {
+	if c.w.IsShuttingDown() {
+		c.record("acquired late")
+	} else {
+		c.record("acquire")
+	}
+	return c.acquireErr
+}
+
+func (c *fakeCoordinator) ReleaseDrainSlot() {
+	if c.w.ActiveConns() != 0 {
+		c.record("released early")
+	} else {
+		c.record("release")
+	}
+}
+
+func TestDrainCoordinator(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestDrainCoordinator: should not be nil")
+	}
+	c := &fakeCoordinator{w: w}
+	err := w.Configure(WithDrainCoordinator(c))
+	if err != nil {
+		t.Fatalf("TestDrainCoordinator: should not have error")
+	}
+	_ = w.AddNamedHook("cleanup", func() error {
+		c.record("hook")
+		return nil
+	})
+	w.RecordConn(true)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		w.RecordConn(false)
+	}()
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestDrainCoordinator: should not have error: %v", err)
+	}
+	if want := []string{"acquire", "hook", "release"}; !reflect.DeepEqual(c.events, want) {
+		t.Errorf("TestDrainCoordinator: should see %v, got %v", want, c.events)
+	}
+	if w.Configure(WithDrainCoordinator(nil)) == nil {
+		t.Errorf("TestDrainCoordinator: should reject a nil coordinator")
+	}
+}
+
+func TestDrainCoordinatorAcquireFails(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestDrainCoordinatorAcquireFails: should not be nil")
+	}
+	acquireErr := errors.New("lock unavailable")
+	c := &fakeCoordinator{w: w, acquireErr: acquireErr}
+	_ = w.Configure(WithDrainCoordinator(c))
+	w.Accepting(true)
+	err := w.OnStop()
+	if !errors.Is(err, acquireErr) {
+		t.Errorf("TestDrainCoordinatorAcquireFails: should return the acquire error, got %v", err)
+	}
+	if w.IsShuttingDown() || !w.IsAccepting() || len(c.events) != 1 {
+		t.Errorf("TestDrainCoordinatorAcquireFails: should not drain, got %v", c.events)
+	}
+}
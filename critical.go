@@ -0,0 +1,45 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+)
+
+// AddCriticalHook registers a hook that `OnStop` always runs once the drain ends,
+// for essential cleanup such as flushing state that would otherwise be lost when
+// the process is killed after the grace period. Critical hooks run serially in
+// registration order before any other hook, and unlike them are never skipped by
+// `WithHardDeadline`, `WithHookBudget` or `WithFailFastHooks`. Only `OnStopNoHooks`
+// skips them.
+//
+// Example use:
+//
+//	err := watcher.AddCriticalHook(journal.Sync)
+func (w *Watcher) AddCriticalHook(f ShutdownHook) error {
+	if w == nil {
+		return errors.New("AddCriticalHook: receiver is nil")
+	}
+	if f == nil {
+		return errors.New("AddCriticalHook: hook is nil")
+	}
+	w.mu.Lock()
+	w.criticalHooks = append(w.criticalHooks, f)
+	w.mu.Unlock()
+	return nil
+}
+
+// criticalHookNames returns the names of the registered critical hooks.
+func (w *Watcher) criticalHookNames() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return shutdownHookNames(w.criticalHooks)
+}
+
+// runCriticalHooks runs the registered critical hooks.
+func (w *Watcher) runCriticalHooks(ctx context.Context) []HookResult {
+	w.mu.Lock()
+	criticalHooks := make([]ShutdownHook, len(w.criticalHooks))
+	copy(criticalHooks, w.criticalHooks)
+	w.mu.Unlock()
+	return runSerial(ctx, criticalHooks)
+}
@@ -0,0 +1,69 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCriticalHook(t *testing.T) {
+	bestEffortRan, criticalRan := false, false
+	w, wErr := NewWatcher(10000, func() error {
+		bestEffortRan = true
+		return nil
+	})
+	if w == nil || wErr != nil {
+		t.Fatalf("TestCriticalHook: should not be nil")
+	}
+	err := w.AddCriticalHook(func() error {
+		criticalRan = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("TestCriticalHook: should not have error")
+	}
+	if w.AddCriticalHook(nil) == nil {
+		t.Errorf("TestCriticalHook: should reject a nil hook")
+	}
+	err = w.Configure(WithHardDeadline(50 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("TestCriticalHook: should not have error")
+	}
+	w.RecordConn(true) // never closed
+	err = w.OnStop()
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) || !shutdownErr.HardDeadline {
+		t.Errorf("TestCriticalHook: should hit the hard deadline, got %v", err)
+	}
+	if !criticalRan {
+		t.Errorf("TestCriticalHook: critical hook should run on the timeout path")
+	}
+	if bestEffortRan {
+		t.Errorf("TestCriticalHook: best-effort hook should be skipped")
+	}
+	if results := w.LastHookResults(); len(results) != 1 {
+		t.Errorf("TestCriticalHook: should report the critical hook, got %v", results)
+	}
+}
+
+func TestCriticalHookFailFast(t *testing.T) {
+	criticalRan := false
+	w, wErr := NewWatcher(1000, func() error {
+		return errors.New("hook failed")
+	})
+	if w == nil || wErr != nil {
+		t.Fatalf("TestCriticalHookFailFast: should not be nil")
+	}
+	_ = w.AddCriticalHook(func() error {
+		criticalRan = true
+		return nil
+	})
+	_ = w.Configure(WithFailFastHooks(true))
+	_ = w.OnStop()
+	if !criticalRan {
+		t.Errorf("TestCriticalHookFailFast: critical hook should run despite fail-fast")
+	}
+	if names := w.Hooks(); len(names) != 2 {
+		t.Errorf("TestCriticalHookFailFast: should list both hooks, got %v", names)
+	}
+}
@@ -0,0 +1,71 @@
+package httpdshutdown
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// WithDiagnosticsWriter makes `OnStop` append one JSON `ShutdownRecord` per shutdown
+// to out, followed by a newline. Unlike the logger, this is meant for machines, such
+// as an audit trail of controlled shutdowns.
+func WithDiagnosticsWriter(out io.Writer) Option {
+	return func(w *Watcher) error {
+		if out == nil {
+			return errors.New("WithDiagnosticsWriter: writer is nil")
+		}
+		w.diagnostics = out
+		return nil
+	}
+}
+
+// ShutdownRecord is the JSON record written by `WithDiagnosticsWriter`.
+type ShutdownRecord struct {
+	Time           time.Time    `json:"time"`
+	DurationMS     int64        `json:"duration_ms"`
+	StartConns     int64        `json:"start_conns"`
+	DrainedConns   int64        `json:"drained_conns"`
+	RemainingConns int64        `json:"remaining_conns"`
+	Hooks          []HookRecord `json:"hooks"`
+	Outcome        string       `json:"outcome"` // "ok", "timed_out", "cancelled", "hook_failed" or "child_failed".
+}
+
+// HookRecord is the outcome of one hook within a `ShutdownRecord`.
+type HookRecord struct {
+	Name       string `json:"name"`
+	DurationMS int64  `json:"duration_ms"`
+	Err        string `json:"error,omitempty"`
+}
+
+// shutdownOutcome summarizes how a shutdown went for a `ShutdownRecord`.
+func shutdownOutcome(e *ShutdownError) string {
+	switch {
+	case e.TimedOut:
+		return "timed_out"
+	case e.Cancelled:
+		return "cancelled"
+	case len(e.HookErrors) != 0:
+		return "hook_failed"
+	case len(e.ChildErrors) != 0:
+		return "child_failed"
+	}
+	return "ok"
+}
+
+// writeDiagnostics writes rec to the diagnostics writer, if there is one. Failing to
+// write does not fail the shutdown, so the error is only logged.
+func (w *Watcher) writeDiagnostics(rec ShutdownRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.diagnostics == nil {
+		return
+	}
+	line, err := json.Marshal(rec)
+	if err == nil {
+		_, err = w.diagnostics.Write(append(line, '\n'))
+	}
+	if err != nil && w.logger != nil {
+		w.logger.Printf("OnStop: could not write diagnostics: %v", err)
+	}
+}
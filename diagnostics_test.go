@@ -0,0 +1,50 @@
+package httpdshutdown
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDiagnosticsWriter(t *testing.T) {
+	w, wErr := NewWatcher(1000, sampleShutdownHook, func() error {
+		return errors.New("hook failed")
+	})
+	if w == nil || wErr != nil {
+		t.Fatalf("TestDiagnosticsWriter: should not be nil")
+	}
+	buf := new(bytes.Buffer)
+	err := w.Configure(WithDiagnosticsWriter(buf))
+	if err != nil {
+		t.Fatalf("TestDiagnosticsWriter: should not have error")
+	}
+	w.RecordConn(true)
+	w.RecordConn(false)
+	if w.OnStop() == nil {
+		t.Errorf("TestDiagnosticsWriter: should have hook error")
+	}
+	if w.OnStop() == nil {
+		t.Errorf("TestDiagnosticsWriter: should have hook error")
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("TestDiagnosticsWriter: should write one line per shutdown, got %q", buf.String())
+	}
+	var rec ShutdownRecord
+	err = json.Unmarshal([]byte(lines[0]), &rec)
+	if err != nil {
+		t.Fatalf("TestDiagnosticsWriter: invalid JSON: %v", err)
+	}
+	if rec.Time.IsZero() || rec.Outcome != "hook_failed" || rec.RemainingConns != 0 {
+		t.Errorf("TestDiagnosticsWriter: unexpected record %+v", rec)
+	}
+	if len(rec.Hooks) != 2 || rec.Hooks[0].Err != "" || rec.Hooks[1].Err != "hook failed" {
+		t.Errorf("TestDiagnosticsWriter: unexpected hooks %+v", rec.Hooks)
+	}
+	if !strings.Contains(rec.Hooks[0].Name, "sampleShutdownHook") {
+		t.Errorf("TestDiagnosticsWriter: hook should be named, got %s", rec.Hooks[0].Name)
+	}
+}
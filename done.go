@@ -0,0 +1,57 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+)
+
+// Done returns a channel that is closed once `OnStop` has completed, whether or not
+// shutdown went cleanly. After `Reset` a new channel is returned for the next
+// shutdown. On a nil Watcher the channel is already closed, as there is nothing to
+// wait for.
+func (w *Watcher) Done() <-chan struct{} {
+	if w == nil {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.doneChanLocked()
+}
+
+// WaitDone blocks until `OnStop` has completed, returning nil, or until ctx is done,
+// returning ctx's error. It can be called before, during or after shutdown.
+//
+// Example use:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//	defer cancel()
+//	err := watcher.WaitDone(ctx)
+func (w *Watcher) WaitDone(ctx context.Context) error {
+	if w == nil {
+		return errors.New("WaitDone: receiver is nil")
+	}
+	select {
+	case <-w.Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// doneChanLocked returns the channel behind `Done`. w.mu must be held.
+func (w *Watcher) doneChanLocked() chan struct{} {
+	if w.doneChan == nil {
+		w.doneChan = make(chan struct{})
+	}
+	return w.doneChan
+}
+
+// markDoneLocked closes the channel behind `Done`. w.mu must be held.
+func (w *Watcher) markDoneLocked() {
+	if !w.done {
+		w.done = true
+		close(w.doneChanLocked())
+	}
+}
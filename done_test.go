@@ -0,0 +1,57 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitDone(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000)
+
+	// Before shutdown has started.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	err := w.WaitDone(ctx)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("TestWaitDone: should time out before shutdown, got %v", err)
+	}
+
+	// While shutdown is in progress.
+	w.RecordConn(true)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	waited := make(chan error, 1)
+	go func() {
+		waited <- w.WaitDone(context.Background())
+	}()
+	select {
+	case <-waited:
+		t.Errorf("TestWaitDone: should wait for shutdown to complete")
+	case <-time.After(10 * time.Millisecond):
+	}
+	w.RecordConn(false)
+	<-errChan
+	if err := <-waited; err != nil {
+		t.Errorf("TestWaitDone: should not have error: %v", err)
+	}
+
+	// After shutdown has finished.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	err = w.WaitDone(ctx)
+	cancel()
+	if err != nil {
+		t.Errorf("TestWaitDone: should return at once after shutdown, got %v", err)
+	}
+
+	_ = w.Reset()
+	select {
+	case <-w.Done():
+		t.Errorf("TestWaitDone: Reset should start a new Done channel")
+	default:
+	}
+}
@@ -0,0 +1,158 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"time"
+)
+
+// WithDrainThreshold makes `OnStop` consider the drain complete once at most n
+// connections remain open and at most n requests are in flight, rather than waiting
+// for all of them. This suits daemons with long-lived connections, such as
+// long-polls, that would otherwise always time out. The remaining connections are
+// left for the daemon to force-close.
+func WithDrainThreshold(n int) Option {
+	return func(w *Watcher) error {
+		if n < 0 {
+			return errors.New("WithDrainThreshold: threshold must not be negative")
+		}
+		w.drainThreshold = int64(n)
+		return nil
+	}
+}
+
+// WithMinDrainDuration makes `OnStop` wait at least d before considering the drain
+// complete, even if no connections are open. This covers requests a load balancer
+// has already routed to the daemon but which have not arrived yet when the signal
+// does. The wait still ends early if the grace period expires.
+func WithMinDrainDuration(d time.Duration) Option {
+	return func(w *Watcher) error {
+		if d < 0 {
+			return errors.New("WithMinDrainDuration: duration must not be negative")
+		}
+		w.minDrain = d
+		return nil
+	}
+}
+
+// WithHardDeadline sets an absolute limit, measured from the start of `OnStop`, on
+// how long the drain may take, separately from the grace period. If connections or
+// managed servers have not drained by then, the servers are force-closed and
+// `OnStop` returns without running any hooks but those added with `AddCriticalHook`,
+// reporting `HardDeadline` in its `ShutdownError`. The deadline is normally shorter
+// than the grace period. Zero means no hard deadline.
+func WithHardDeadline(d time.Duration) Option {
+	return func(w *Watcher) error {
+		if d < 0 {
+			return errors.New("WithHardDeadline: deadline must not be negative")
+		}
+		w.hardDeadline = d
+		return nil
+	}
+}
+
+// OnConnClosedDuringDrain registers fn to be called each time a connection closes
+// while the Watcher is shutting down, with the number of connections still open.
+// This gives finer grained progress than `WithDrainProgress`. fn is called after
+// the Watcher's lock is released, so it may call the Watcher's methods, but calls
+// from connections closing concurrently are not ordered.
+func (w *Watcher) OnConnClosedDuringDrain(fn func(remaining int64)) error {
+	if w == nil {
+		return errors.New("OnConnClosedDuringDrain: receiver is nil")
+	}
+	if fn == nil {
+		return errors.New("OnConnClosedDuringDrain: callback is nil")
+	}
+	w.mu.Lock()
+	w.closedDuringDrain = fn
+	w.mu.Unlock()
+	return nil
+}
+
+// OnQuiesced registers fn to be called once, the first time no connections are open
+// while the Watcher is shutting down. Unlike the return of `OnStop`, this is not
+// affected by `WithDrainThreshold` or the grace period, so it marks the moment the
+// daemon is fully quiesced. fn is called after the Watcher's lock is released.
+func (w *Watcher) OnQuiesced(fn func()) error {
+	if w == nil {
+		return errors.New("OnQuiesced: receiver is nil")
+	}
+	if fn == nil {
+		return errors.New("OnQuiesced: callback is nil")
+	}
+	w.mu.Lock()
+	w.quiescedFn = fn
+	w.mu.Unlock()
+	return nil
+}
+
+// checkQuiescedLocked arranges for the `OnQuiesced` callback to be called by
+// `unlockAndNotify` if the drain has just quiesced. w.mu must be held.
+func (w *Watcher) checkQuiescedLocked() {
+	if w.shuttingDown && w.activeConns.Load() == 0 && !w.quiesced {
+		w.quiesced = true
+		w.pendingQuiesced = w.quiescedFn != nil
+	}
+}
+
+// unlockAndNotify releases w.mu, then calls the `OnAcceptingChanged`,
+// `OnConnClosedDuringDrain` and `OnQuiesced` callbacks for any events that happened
+// while it was held.
+func (w *Watcher) unlockAndNotify() {
+	pending, fn := w.drainCloses, w.closedDuringDrain
+	quiesced, quiescedFn := w.pendingQuiesced, w.quiescedFn
+	changes, acceptingFn := w.acceptingChanges, w.acceptingFn
+	w.drainCloses = nil
+	w.pendingQuiesced = false
+	w.acceptingChanges = nil
+	w.mu.Unlock()
+	for _, accepting := range changes {
+		acceptingFn(accepting)
+	}
+	for _, remaining := range pending {
+		fn(remaining)
+	}
+	if quiesced {
+		quiescedFn()
+	}
+}
+
+// waitDrained returns a channel that is closed once at most threshold connections
+// are open, at most threshold requests are in flight in `TrackHandler` and every
+// worker counted by `WorkerStarted` has finished. All must drain within the one
+// grace period; a daemon that does not use `TrackHandler` or workers only has its
+// connections waited on.
+func (w *Watcher) waitDrained(threshold int64, done <-chan struct{}) <-chan struct{} {
+	return w.waitLocked(func() bool {
+		return w.activeConns.Load() <= threshold && w.inFlight <= threshold && w.workers == 0
+	}, done)
+}
+
+// waitLocked returns a channel that is closed once cond, which is called with w.mu
+// held, returns true. Closing done abandons the wait; the goroutines started here
+// exit either way, so a drain that times out does not leave a waiter behind. cond is
+// rechecked whenever connsCond is signalled rather than polled, so drain completion
+// is detected as soon as it happens and there is no poll interval to tune.
+func (w *Watcher) waitLocked(cond func() bool, done <-chan struct{}) <-chan struct{} {
+	drained := make(chan struct{})
+	go func() {
+		// Wake the waiter below so it notices done has been closed.
+		<-done
+		w.mu.Lock()
+		w.connsCond.Broadcast()
+		w.mu.Unlock()
+	}()
+	go func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		for !cond() {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			w.connsCond.Wait()
+		}
+		close(drained)
+	}()
+	return drained
+}
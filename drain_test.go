@@ -0,0 +1,264 @@
+package httpdshutdown
+
+import (
+	"net/http"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDrainThreshold(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestDrainThreshold: should not be nil")
+	}
+	err := w.Configure(WithDrainThreshold(1))
+	if err != nil {
+		t.Fatalf("TestDrainThreshold: should not have error")
+	}
+	w.RecordConn(true)
+	w.RecordConn(true)
+	w.RecordConn(true) // a long-poll that never closes
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		w.RecordConn(false)
+		w.RecordConn(false)
+	}()
+	start := time.Now()
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestDrainThreshold: should not have error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("TestDrainThreshold: should return once the threshold is reached, took %v", elapsed)
+	}
+	if w.ActiveConns() != 1 {
+		t.Errorf("TestDrainThreshold: should leave 1 conn open, got %d", w.ActiveConns())
+	}
+}
+
+func TestDrainThresholdNegative(t *testing.T) {
+	w, _ := NewWatcher(3000)
+	if w.Configure(WithDrainThreshold(-1)) == nil {
+		t.Errorf("TestDrainThresholdNegative: should have error")
+	}
+}
+
+func TestRapidOpenClose(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestRapidOpenClose: should not be nil")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				w.RecordConn(true)
+				w.RecordConn(false)
+			}
+		}()
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		wg.Wait()
+		errChan <- w.OnStop()
+	}()
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Errorf("TestRapidOpenClose: should not have error: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("TestRapidOpenClose: OnStop should have returned")
+	}
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestRapidOpenClose: should have 0 active conns, got %d", w.ActiveConns())
+	}
+}
+
+func TestDrainDuringOpenClose(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestDrainDuringOpenClose: should not be nil")
+	}
+	w.RecordConn(true)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				w.RecordConn(true)
+				_ = w.ActiveConns()
+				w.RecordConn(false)
+			}
+		}()
+	}
+	wg.Wait()
+	w.RecordConn(false)
+	err := <-errChan
+	if err != nil {
+		t.Errorf("TestDrainDuringOpenClose: should not have error: %v", err)
+	}
+}
+
+func TestUnderflow(t *testing.T) {
+	w, _ := NewWatcher(1000)
+	w.RecordConn(false)
+	w.RecordConnState(http.StateClosed)
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestUnderflow: count should stay at zero, got %d", w.ActiveConns())
+	}
+	w.RecordConn(true)
+	if w.ActiveConns() != 1 {
+		t.Errorf("TestUnderflow: should count a conn opened after an underflow")
+	}
+}
+
+func TestMinDrainDuration(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 10000)
+	err := w.Configure(WithMinDrainDuration(5 * time.Second))
+	if err != nil {
+		t.Fatalf("TestMinDrainDuration: should not have error")
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	// The grace period and the minimum drain.
+	clock.BlockUntil(2)
+	clock.Advance(4 * time.Second)
+	select {
+	case <-errChan:
+		t.Fatalf("TestMinDrainDuration: should wait out the minimum with no conns open")
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	err = <-errChan
+	if err != nil {
+		t.Errorf("TestMinDrainDuration: should not have error: %v", err)
+	}
+}
+
+func TestMinDrainDurationLongerThanTimeout(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 1000)
+	err := w.Configure(WithMinDrainDuration(5 * time.Second))
+	if err != nil {
+		t.Fatalf("TestMinDrainDurationLongerThanTimeout: should not have error")
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(2)
+	clock.Advance(time.Second)
+	err = <-errChan
+	if err != nil {
+		t.Errorf("TestMinDrainDurationLongerThanTimeout: drained conns should not time out: %v", err)
+	}
+}
+
+func TestOnConnClosedDuringDrain(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000)
+	remaining := make(chan int64, 10)
+	err := w.OnConnClosedDuringDrain(func(n int64) {
+		remaining <- n
+	})
+	if err != nil {
+		t.Fatalf("TestOnConnClosedDuringDrain: should not have error")
+	}
+	w.RecordConnState(http.StateNew)
+	w.RecordConnState(http.StateNew)
+	w.RecordConnState(http.StateNew)
+	w.RecordConnState(http.StateNew)
+	w.RecordConnState(http.StateClosed)
+	if len(remaining) != 0 {
+		t.Errorf("TestOnConnClosedDuringDrain: should not fire before the drain")
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	for _, want := range []int64{2, 1, 0} {
+		w.RecordConnState(http.StateClosed)
+		if got := <-remaining; got != want {
+			t.Errorf("TestOnConnClosedDuringDrain: should report %d remaining, got %d", want, got)
+		}
+	}
+	err = <-errChan
+	if err != nil {
+		t.Errorf("TestOnConnClosedDuringDrain: should not have error: %v", err)
+	}
+}
+
+func TestOnQuiesced(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestOnQuiesced: should not be nil")
+	}
+	err := w.Configure(WithDrainThreshold(1))
+	if err != nil {
+		t.Fatalf("TestOnQuiesced: should not have error")
+	}
+	fired := 0
+	err = w.OnQuiesced(func() {
+		fired++
+	})
+	if err != nil {
+		t.Fatalf("TestOnQuiesced: should not have error")
+	}
+	w.RecordConn(true)
+	w.RecordConn(true)
+	w.RecordConn(false)
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestOnQuiesced: should not have error: %v", err)
+	}
+	if fired != 0 {
+		t.Errorf("TestOnQuiesced: should not fire with conns open")
+	}
+	w.RecordConn(false)
+	if fired != 1 {
+		t.Errorf("TestOnQuiesced: should fire when the last conn closes, fired %d times", fired)
+	}
+	w.RecordConn(true)
+	w.RecordConn(false)
+	w.RecordConn(false)
+	if fired != 1 {
+		t.Errorf("TestOnQuiesced: should fire exactly once, fired %d times", fired)
+	}
+}
+
+func TestTimedOutDrainNoLeak(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 1000)
+	w.RecordConn(true) // never closes
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- w.OnStop()
+		}()
+		clock.BlockUntil(1)
+		clock.Advance(time.Second)
+		if <-errChan == nil {
+			t.Fatalf("TestTimedOutDrainNoLeak: should time out")
+		}
+	}
+
+	// Give exiting goroutines a moment to be reaped.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("TestTimedOutDrainNoLeak: leaked %d goroutines", after-before)
+	}
+}
@@ -0,0 +1,30 @@
+package httpdshutdown
+
+// WithDryRun makes `OnStop` log the shutdown it would perform instead of performing
+// it: the connections it would drain and for how long, the servers it would shut
+// down and the hooks it would run, in order. Nothing is stopped, so the Watcher
+// keeps accepting and `OnStop` returns nil at once. This is for checking shutdown
+// wiring in staging; it needs a logger set with `WithLogger` to be of any use.
+func WithDryRun(dryRun bool) Option {
+	return func(w *Watcher) error {
+		w.dryRun = dryRun
+		return nil
+	}
+}
+
+// dryRunStop implements `OnStop` under `WithDryRun`.
+func (w *Watcher) dryRunStop(cfg stopConfig) error {
+	w.mu.Lock()
+	conns, servers, threshold := w.activeConns.Load(), len(w.servers)+len(w.stoppers), w.drainThreshold
+	w.mu.Unlock()
+	gracePeriod := w.gracePeriod(cfg)
+	w.logf("OnStop: dry run: would drain %d connections to %d with grace period %v", conns, threshold, gracePeriod)
+	w.logf("OnStop: dry run: would shut down %d managed servers", servers)
+	if cfg.skipHooks {
+		return nil
+	}
+	for i, name := range w.Hooks() {
+		w.logf("OnStop: dry run: would run hook %d: %s", i+1, name)
+	}
+	return nil
+}
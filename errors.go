@@ -0,0 +1,60 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCancelled is matched by the error `OnStop` returns when the drain was aborted
+// with `Cancel`.
+var ErrCancelled = errors.New("OnStop: shutdown cancelled")
+
+// ErrHookBudgetExceeded is matched by the error reported when hooks were skipped
+// because the `WithHookBudget` budget ran out.
+var ErrHookBudgetExceeded = errors.New("hook budget exceeded")
+
+// ShutdownError is returned by `OnStop` when shutdown did not go cleanly. Callers can
+// use `errors.As` to find out why.
+type ShutdownError struct {
+	TimedOut       bool    // The grace period expired before connections drained.
+	Cancelled      bool    // The drain was aborted with Cancel.
+	HardDeadline   bool    // The WithHardDeadline deadline passed, so hooks were skipped.
+	RemainingConns int64   // Connections still open when the drain ended.
+	HookErrors     []error // Errors returned by shutdown hooks, in order.
+	ChildErrors    []error // Errors returned by the OnStop of children added with AddChild.
+}
+
+func (e *ShutdownError) failed() bool {
+	return e.TimedOut || e.Cancelled || len(e.HookErrors) != 0 || len(e.ChildErrors) != 0
+}
+
+func (e *ShutdownError) Error() string {
+	msgs := make([]string, 0)
+	if e.HardDeadline {
+		msgs = append(msgs, fmt.Sprintf("shutdown hit its hard deadline with %d connections open", e.RemainingConns))
+	} else if e.TimedOut {
+		msgs = append(msgs, fmt.Sprintf("shutdown timed out with %d connections open", e.RemainingConns))
+	}
+	if e.Cancelled {
+		msgs = append(msgs, "shutdown cancelled")
+	}
+	for _, err := range e.HookErrors {
+		msgs = append(msgs, "shutdown hook err: "+err.Error())
+	}
+	for _, err := range e.ChildErrors {
+		msgs = append(msgs, "child shutdown err: "+err.Error())
+	}
+	return "OnStop: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the hook and child errors, along with `ErrCancelled` if the drain
+// was cancelled, so they can be matched with `errors.Is`.
+func (e *ShutdownError) Unwrap() []error {
+	errs := make([]error, 0, len(e.HookErrors)+len(e.ChildErrors)+1)
+	if e.Cancelled {
+		errs = append(errs, ErrCancelled)
+	}
+	errs = append(errs, e.HookErrors...)
+	return append(errs, e.ChildErrors...)
+}
@@ -0,0 +1,55 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShutdownErrorTimeout(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000)
+	w.RecordConn(true)
+	w.RecordConn(true)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(3 * time.Second)
+	err := <-errChan
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) {
+		t.Fatalf("TestShutdownErrorTimeout: should be a ShutdownError, got %v", err)
+	}
+	if !shutdownErr.TimedOut || shutdownErr.Cancelled {
+		t.Errorf("TestShutdownErrorTimeout: should have timed out: %+v", shutdownErr)
+	}
+	if shutdownErr.RemainingConns != 2 {
+		t.Errorf("TestShutdownErrorTimeout: should have 2 remaining conns, got %d", shutdownErr.RemainingConns)
+	}
+	if len(shutdownErr.HookErrors) != 0 {
+		t.Errorf("TestShutdownErrorTimeout: should have no hook errors")
+	}
+}
+
+func TestShutdownErrorHooks(t *testing.T) {
+	hookErr := errors.New("hook failed")
+	w, wErr := NewWatcher(3000, sampleShutdownHook, func() error { return hookErr })
+	if w == nil || wErr != nil {
+		t.Fatalf("TestShutdownErrorHooks: should not be nil")
+	}
+	err := w.OnStop()
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) {
+		t.Fatalf("TestShutdownErrorHooks: should be a ShutdownError, got %v", err)
+	}
+	if shutdownErr.TimedOut || shutdownErr.Cancelled || shutdownErr.RemainingConns != 0 {
+		t.Errorf("TestShutdownErrorHooks: should have drained cleanly: %+v", shutdownErr)
+	}
+	if len(shutdownErr.HookErrors) != 1 || !errors.Is(err, hookErr) {
+		t.Errorf("TestShutdownErrorHooks: should carry the hook error: %+v", shutdownErr)
+	}
+	if errors.Is(err, ErrCancelled) {
+		t.Errorf("TestShutdownErrorHooks: should not match ErrCancelled")
+	}
+}
@@ -0,0 +1,41 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+)
+
+// WithExpvar publishes the Watcher's counters as expvar variables named with prefix,
+// so daemons already serving /debug/vars get shutdown metrics with no further wiring.
+// The variables are prefix_active_conns, prefix_peak_conns, prefix_rejected_conns
+// and prefix_last_drain_ms, and are read from the Watcher each time they are served.
+// As expvar names are global and cannot be unpublished, it is an error to use a
+// prefix that is already taken.
+//
+// Example use:
+//
+//	err := watcher.Configure(httpdshutdown.WithExpvar("httpd"))
+func WithExpvar(prefix string) Option {
+	return func(w *Watcher) error {
+		if prefix == "" {
+			return errors.New("WithExpvar: prefix is empty")
+		}
+		vars := map[string]func() any{
+			"active_conns":   func() any { return w.ActiveConns() },
+			"peak_conns":     func() any { return w.PeakConns() },
+			"rejected_conns": func() any { return w.RejectedConns() },
+			"last_drain_ms":  func() any { return w.LastDrainDuration().Milliseconds() },
+		}
+		for name := range vars {
+			if expvar.Get(prefix+"_"+name) != nil {
+				// Checked up front, as expvar.Publish panics on a duplicate.
+				return fmt.Errorf("WithExpvar: %s_%s is already published", prefix, name)
+			}
+		}
+		for name, f := range vars {
+			expvar.Publish(prefix+"_"+name, expvar.Func(f))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,74 @@
+package httpdshutdown
+
+import (
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWithExpvar(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestWithExpvar: should not be nil")
+	}
+	// expvar names can't be unpublished, so each run needs its own prefix.
+	prefix := fmt.Sprintf("testexpvar%d", time.Now().UnixNano())
+	if err := w.Configure(WithExpvar(prefix)); err != nil {
+		t.Fatalf("TestWithExpvar: should configure, got %v", err)
+	}
+	get := func(name string) string {
+		v := expvar.Get(prefix + "_" + name)
+		if v == nil {
+			t.Fatalf("TestWithExpvar: %s should be published", name)
+		}
+		return v.String()
+	}
+	w.Accepting(true)
+	w.RecordConn(true)
+	w.RecordConn(true)
+	w.RecordConn(false)
+	if got := get("active_conns"); got != "1" {
+		t.Errorf("TestWithExpvar: active_conns should be 1, got %s", got)
+	}
+	if got := get("peak_conns"); got != "2" {
+		t.Errorf("TestWithExpvar: peak_conns should be 2, got %s", got)
+	}
+	w.Accepting(false)
+	w.ConnOpened()
+	if got := get("rejected_conns"); got != "1" {
+		t.Errorf("TestWithExpvar: rejected_conns should be 1, got %s", got)
+	}
+	if got := get("last_drain_ms"); got != "0" {
+		t.Errorf("TestWithExpvar: last_drain_ms should be 0 before a drain, got %s", got)
+	}
+
+	w2, _ := NewWatcher(3000)
+	if w2.Configure(WithExpvar(prefix)) == nil {
+		t.Errorf("TestWithExpvar: should have error for a prefix already published")
+	}
+	if w2.Configure(WithExpvar("")) == nil {
+		t.Errorf("TestWithExpvar: should have error for an empty prefix")
+	}
+}
+
+func TestLastDrainDuration(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000)
+	w.RecordConn(true) // never closed
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(3 * time.Second)
+	<-errChan
+	if d := w.LastDrainDuration(); d != 3*time.Second {
+		t.Errorf("TestLastDrainDuration: should be 3s, got %v", d)
+	}
+	if err := w.Reset(); err != nil {
+		t.Fatalf("TestLastDrainDuration: should reset, got %v", err)
+	}
+	if d := w.LastDrainDuration(); d != 0 {
+		t.Errorf("TestLastDrainDuration: should be cleared by Reset, got %v", d)
+	}
+}
@@ -0,0 +1,71 @@
+package httpdshutdown
+
+import "net/http"
+
+// updateFastPathLocked works out whether `RecordConnState`, `ConnOpened` and
+// `ConnClosed` may count connections without taking w.mu. That is so while nothing
+// but the count depends on them: the Watcher is accepting connections and not
+// shutting down, connection states are not being logged, and they are counted
+// with the default mapping. It must be called whenever one of those changes. w.mu
+// must be held.
+func (w *Watcher) updateFastPathLocked() {
+	w.fastPath.Store(!w.rejecting && !w.shuttingDown && !w.connStateLogging &&
+		w.connStateMapping == nil)
+}
+
+// countConnFast counts newState with atomics alone if the fast path is on, returning
+// false if the caller must take w.mu and count it instead. StateActive and StateIdle
+// need no counting at all on the fast path, and they are the most frequent states
+// as every request on a keep-alive connection passes through both.
+func (w *Watcher) countConnFast(newState http.ConnState) bool {
+	if !w.fastPath.Load() {
+		return false
+	}
+	switch newState {
+	case http.StateNew:
+		w.raisePeak(w.activeConns.Add(1))
+	case http.StateClosed, http.StateHijacked:
+		if !w.decrementConns() {
+			// Let the slow path record the unbalanced close.
+			return false
+		}
+	default:
+		return true
+	}
+	if !w.fastPath.Load() {
+		// The fast path was turned off while we counted, such as by OnStop, which
+		// may already be waiting on the count. Report it as the slow path would.
+		w.mu.Lock()
+		if newState == http.StateNew {
+			w.connAddedLocked()
+		} else {
+			w.connRemovedLocked()
+		}
+		w.unlockAndNotify()
+	}
+	return true
+}
+
+// raisePeak records n open connections as the high-water mark if it is one.
+func (w *Watcher) raisePeak(n int64) {
+	for {
+		peak := w.peakConns.Load()
+		if n <= peak || w.peakConns.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+// decrementConns counts a closed connection, returning false without changing the
+// count if it is already zero.
+func (w *Watcher) decrementConns() bool {
+	for {
+		n := w.activeConns.Load()
+		if n == 0 {
+			return false
+		}
+		if w.activeConns.CompareAndSwap(n, n-1) {
+			return true
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ShutdownStats describes how a shutdown went, for `FinalHook`s.
+type ShutdownStats struct {
+	Duration       time.Duration // Time from the start of OnStop until the final hooks ran.
+	StartConns     int64         // Connections open when OnStop began.
+	DrainedConns   int64         // Connections that closed during the drain.
+	RemainingConns int64         // Connections still open when the drain ended.
+	TimedOut       bool          // The grace period expired before connections drained.
+	Cancelled      bool          // The drain was aborted with Cancel.
+	HookErrors     []error       // Errors returned by the other shutdown hooks, in order.
+}
+
+// FinalHook is a shutdown hook that is told how the shutdown went, so it can adapt,
+// such as dumping diagnostics when the drain timed out.
+type FinalHook func(stats ShutdownStats) error
+
+// AddFinalHook registers a hook to be run by `OnStop` after the drain and all other
+// hooks, with the outcome of the shutdown so far. Final hooks run serially in
+// registration order and their results are included in `LastHookResults`.
+//
+// Example use:
+//
+//	err := watcher.AddFinalHook(func(stats httpdshutdown.ShutdownStats) error {
+//	        if stats.TimedOut {
+//	                log.Printf("%d connections were cut off", stats.RemainingConns)
+//	        }
+//	        return nil
+//	})
+func (w *Watcher) AddFinalHook(f FinalHook) error {
+	if w == nil {
+		return errors.New("AddFinalHook: receiver is nil")
+	}
+	if f == nil {
+		return errors.New("AddFinalHook: hook is nil")
+	}
+	w.mu.Lock()
+	w.finalHooks = append(w.finalHooks, f)
+	w.mu.Unlock()
+	return nil
+}
+
+// runFinalHooks runs the registered final hooks with stats.
+func (w *Watcher) runFinalHooks(ctx context.Context, stats ShutdownStats) []HookResult {
+	w.mu.Lock()
+	finalHooks := make([]FinalHook, len(w.finalHooks))
+	copy(finalHooks, w.finalHooks)
+	w.mu.Unlock()
+	results := make([]HookResult, len(finalHooks))
+	for i, f := range finalHooks {
+		f := f
+		h := hook{name: funcName(f), run: func(context.Context, <-chan struct{}) error {
+			return f(stats)
+		}}
+		results[i] = h.runTimed(ctx, nil)
+	}
+	return results
+}
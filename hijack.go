@@ -0,0 +1,61 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"net"
+	"net/http"
+)
+
+// WithHijackTracking makes `RecordConnStateConn` keep counting a connection after
+// it is hijacked, such as for a websocket upgrade, until the handler that took it
+// over calls `ReleaseHijacked`. The drain then waits for long-lived upgraded
+// connections rather than treating them as closed the moment they are hijacked.
+//
+// A handler that abandons its connection without releasing it would hold up every
+// drain until the grace period expires, so when `OnStop` times out it gives up on
+// the hijacked connections still counted, no longer counts them and logs how many
+// there were.
+func WithHijackTracking(enabled bool) Option {
+	return func(w *Watcher) error {
+		w.trackHijacked = enabled
+		return nil
+	}
+}
+
+// ReleaseHijacked stops counting c, a connection recorded as hijacked while
+// `WithHijackTracking` is on. It should be called once the handler is done with c,
+// typically deferred right after `Hijack`.
+//
+// Example use:
+//
+//	conn, _, err := rw.(http.Hijacker).Hijack()
+//	if err != nil {
+//	        return
+//	}
+//	defer watcher.ReleaseHijacked(conn)
+//	defer conn.Close()
+func (w *Watcher) ReleaseHijacked(c net.Conn) error {
+	if w == nil {
+		return errors.New("ReleaseHijacked: receiver is nil")
+	}
+	w.mu.Lock()
+	defer w.unlockAndNotify()
+	if info, ok := w.conns[c]; !ok || info.state != http.StateHijacked {
+		return errors.New("ReleaseHijacked: connection is not tracked as hijacked")
+	}
+	w.dropConnLocked(c)
+	return nil
+}
+
+// abandonHijackedLocked stops counting the hijacked connections that were never
+// released and returns how many there were. w.mu must be held.
+func (w *Watcher) abandonHijackedLocked() int64 {
+	abandoned := int64(0)
+	for c, info := range w.conns {
+		if info.stateSeen && info.state == http.StateHijacked {
+			w.dropConnLocked(c)
+			abandoned++
+		}
+	}
+	return abandoned
+}
@@ -0,0 +1,121 @@
+package httpdshutdown
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newHijackServer starts a server whose handler hijacks each connection and hands it
+// to hijacked.
+func newHijackServer(t *testing.T, w *Watcher, hijacked chan<- net.Conn) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, _, err := rw.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("should hijack, got %v", err)
+			return
+		}
+		hijacked <- conn
+	}))
+	ts.Config.ConnState = w.RecordConnStateConn
+	ts.Start()
+	return ts
+}
+
+func TestHijackTrackingAbandoned(t *testing.T) {
+	w, wErr := NewWatcher(200)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestHijackTrackingAbandoned: should not be nil")
+	}
+	buf := new(bytes.Buffer)
+	if err := w.Configure(WithLogger(log.New(buf, "", 0)), WithHijackTracking(true)); err != nil {
+		t.Fatalf("TestHijackTrackingAbandoned: should configure, got %v", err)
+	}
+	hijacked := make(chan net.Conn, 1)
+	ts := newHijackServer(t, w, hijacked)
+	defer ts.Close()
+	client, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("TestHijackTrackingAbandoned: should dial, got %v", err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n")); err != nil {
+		t.Fatalf("TestHijackTrackingAbandoned: should write, got %v", err)
+	}
+	conn := <-hijacked // abandoned: never released
+	defer conn.Close()
+	if n := w.ActiveConns(); n != 1 {
+		t.Fatalf("TestHijackTrackingAbandoned: hijacked conn should stay counted, got %d", n)
+	}
+
+	before := runtime.NumGoroutine()
+	err = w.OnStop()
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) || !shutdownErr.TimedOut {
+		t.Errorf("TestHijackTrackingAbandoned: should time out, got %v", err)
+	}
+	if shutdownErr != nil && shutdownErr.RemainingConns != 0 {
+		t.Errorf("TestHijackTrackingAbandoned: abandoned conn should not remain, got %d", shutdownErr.RemainingConns)
+	}
+	if n := w.ActiveConns(); n != 0 {
+		t.Errorf("TestHijackTrackingAbandoned: abandoned conn should no longer be counted, got %d", n)
+	}
+	if !strings.Contains(buf.String(), "gave up on 1 abandoned hijacked connections") {
+		t.Errorf("TestHijackTrackingAbandoned: should log the abandoned conn, got %q", buf.String())
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("TestHijackTrackingAbandoned: should not leak the wait goroutines, %d > %d", n, before)
+	}
+}
+
+func TestHijackTrackingReleased(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestHijackTrackingReleased: should not be nil")
+	}
+	if err := w.Configure(WithHijackTracking(true)); err != nil {
+		t.Fatalf("TestHijackTrackingReleased: should configure, got %v", err)
+	}
+	hijacked := make(chan net.Conn, 1)
+	ts := newHijackServer(t, w, hijacked)
+	defer ts.Close()
+	client, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("TestHijackTrackingReleased: should dial, got %v", err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n")); err != nil {
+		t.Fatalf("TestHijackTrackingReleased: should write, got %v", err)
+	}
+	conn := <-hijacked
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	select {
+	case <-errChan:
+		t.Fatalf("TestHijackTrackingReleased: should wait for the hijacked conn")
+	case <-time.After(50 * time.Millisecond):
+	}
+	conn.Close()
+	if err := w.ReleaseHijacked(conn); err != nil {
+		t.Errorf("TestHijackTrackingReleased: should release, got %v", err)
+	}
+	if err := <-errChan; err != nil {
+		t.Errorf("TestHijackTrackingReleased: should drain once released, got %v", err)
+	}
+	if w.ReleaseHijacked(conn) == nil {
+		t.Errorf("TestHijackTrackingReleased: should have error releasing twice")
+	}
+}
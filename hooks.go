@@ -0,0 +1,394 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ContextHook is a shutdown hook that can observe cancellation through its context.
+type ContextHook func(ctx context.Context) error
+
+// CancelableHook is a shutdown hook that is passed a channel which is closed when
+// the grace period expires, so long-running work can be abandoned in time.
+//
+// Example hook:
+//
+//	func flush(stop <-chan struct{}) error {
+//	        for buf.Len() > 0 {
+//	                select {
+//	                case <-stop:
+//	                        return errors.New("flush abandoned")
+//	                default:
+//	                }
+//	                flushChunk(buf)
+//	        }
+//	        return nil
+//	}
+type CancelableHook func(stop <-chan struct{}) error
+
+// hookFunc is the common form every kind of hook is adapted to.
+type hookFunc func(ctx context.Context, stop <-chan struct{}) error
+
+func (f ShutdownHook) hookFunc() hookFunc {
+	return func(context.Context, <-chan struct{}) error {
+		return f()
+	}
+}
+
+func (f ContextHook) hookFunc() hookFunc {
+	return func(ctx context.Context, _ <-chan struct{}) error {
+		return f(ctx)
+	}
+}
+
+func (f CancelableHook) hookFunc() hookFunc {
+	return func(_ context.Context, stop <-chan struct{}) error {
+		return f(stop)
+	}
+}
+
+// hook is a registered shutdown hook along with the name it is reported under.
+type hook struct {
+	name   string
+	run    hookFunc
+	phased bool // Registered with AddHookToPhase.
+	phase  int
+}
+
+// HookResult records the outcome of a single hook run.
+type HookResult struct {
+	Name     string        // The function name of the hook.
+	Duration time.Duration // How long the hook took to return.
+	Err      error         // The error returned by the hook, if any.
+}
+
+// funcName returns the name the runtime knows f by, which is used to name hooks.
+func funcName(f interface{}) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	return fn.Name()
+}
+
+// runTimed runs the hook and records how it went. A panicking hook is recovered and
+// its panic reported as the hook's error, so the remaining hooks still run.
+func (h hook) runTimed(ctx context.Context, stop <-chan struct{}) (result HookResult) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			result.Err = fmt.Errorf("hook %s panicked: %v", h.name, r)
+		}
+		result.Name = h.name
+		result.Duration = time.Since(start)
+	}()
+	result.Err = h.run(ctx, stop)
+	return result
+}
+
+// runSerial runs fs one after another, for the kinds of hook that are kept apart
+// from the regular hooks.
+func runSerial(ctx context.Context, fs []ShutdownHook) []HookResult {
+	results := make([]HookResult, len(fs))
+	for i, f := range fs {
+		h := hook{name: funcName(f), run: f.hookFunc()}
+		results[i] = h.runTimed(ctx, nil)
+	}
+	return results
+}
+
+// shutdownHookNames returns the names fs are reported under.
+func shutdownHookNames(fs []ShutdownHook) []string {
+	names := make([]string, len(fs))
+	for i, f := range fs {
+		names[i] = funcName(f)
+	}
+	return names
+}
+
+// AddContextHook registers a context-aware hook to be run at shutdown, after any
+// hooks already registered.
+func (w *Watcher) AddContextHook(f ContextHook) error {
+	if w == nil {
+		return errors.New("AddContextHook: receiver is nil")
+	}
+	w.addHook(hook{name: funcName(f), run: f.hookFunc()})
+	return nil
+}
+
+// AddNamedHook registers a hook to be run at shutdown under the given name, after any
+// hooks already registered. The name is reported by `Hooks` and `LastHookResults`
+// in place of the function name, which is unhelpful for closures.
+func (w *Watcher) AddNamedHook(name string, f ShutdownHook) error {
+	if w == nil {
+		return errors.New("AddNamedHook: receiver is nil")
+	}
+	if name == "" {
+		return errors.New("AddNamedHook: name is empty")
+	}
+	w.addHook(hook{name: name, run: f.hookFunc()})
+	return nil
+}
+
+// AddCancelableHook registers a hook that can observe the grace period expiring, to
+// be run at shutdown after any hooks already registered. When the drain itself times
+// out, the hook's channel is already closed by the time it runs.
+func (w *Watcher) AddCancelableHook(f CancelableHook) error {
+	if w == nil {
+		return errors.New("AddCancelableHook: receiver is nil")
+	}
+	w.addHook(hook{name: funcName(f), run: f.hookFunc()})
+	return nil
+}
+
+// AddRetryableHook registers a hook that is retried, waiting backoff between
+// attempts, until it succeeds or has been tried attempts times. Retries stop early
+// once the grace period expires, and the last error is reported.
+//
+// Example use:
+//
+//	err := watcher.AddRetryableHook(flushRemote, 3, 100*time.Millisecond)
+func (w *Watcher) AddRetryableHook(f ShutdownHook, attempts int, backoff time.Duration) error {
+	if w == nil {
+		return errors.New("AddRetryableHook: receiver is nil")
+	}
+	if attempts < 1 {
+		return errors.New("AddRetryableHook: attempts must be at least 1")
+	}
+	if backoff < 0 {
+		return errors.New("AddRetryableHook: backoff must not be negative")
+	}
+	run := func(ctx context.Context, stop <-chan struct{}) error {
+		var err error
+		for i := 0; i < attempts; i++ {
+			if i > 0 {
+				w.mu.Lock()
+				clock := w.clock
+				w.mu.Unlock()
+				select {
+				case <-stop:
+					return err
+				case <-ctx.Done():
+					return err
+				case <-clock.After(backoff):
+				}
+			}
+			err = f()
+			if err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+	w.addHook(hook{name: funcName(f), run: run})
+	return nil
+}
+
+// WithFailFastHooks makes `RunHooks` and `OnStop` stop running hooks as soon as one
+// fails, for when a critical early hook failing makes the rest pointless or unsafe.
+// By default every hook runs regardless of earlier failures.
+func WithFailFastHooks(failFast bool) Option {
+	return func(w *Watcher) error {
+		w.failFastHooks = failFast
+		return nil
+	}
+}
+
+// WithHookBudget caps the total time `RunHooks` and `OnStop` spend running hooks,
+// independently of the grace period. Once the budget is spent, cancelable hooks see
+// their channel closed and hooks that have not started are skipped, with an error
+// wrapping `ErrHookBudgetExceeded` that names them. Zero means no budget.
+func WithHookBudget(d time.Duration) Option {
+	return func(w *Watcher) error {
+		if d < 0 {
+			return errors.New("WithHookBudget: budget must not be negative")
+		}
+		w.hookBudget = d
+		return nil
+	}
+}
+
+// WithHookConcurrency caps how many hooks run at once, for hooks that share a
+// limited resource such as a connection pool. It applies to the hooks of each phase
+// added with `AddHookToPhase` and to `RunHooksGroup`. Zero means no limit.
+func WithHookConcurrency(n int) Option {
+	return func(w *Watcher) error {
+		if n < 0 {
+			return errors.New("WithHookConcurrency: limit must not be negative")
+		}
+		w.hookConcurrency = n
+		return nil
+	}
+}
+
+// budgetStop returns a stop channel for hooks that is closed when either stop is
+// closed or the budget expires, and a channel that is closed only in the latter case.
+// The goroutine started here exits once finished is closed.
+func budgetStop(expired <-chan time.Time, stop <-chan struct{}, finished <-chan struct{}) (<-chan struct{}, <-chan struct{}) {
+	hookStop := make(chan struct{})
+	exhausted := make(chan struct{})
+	go func() {
+		select {
+		case <-expired:
+			close(exhausted)
+		case <-stop:
+		case <-finished:
+			return
+		}
+		close(hookStop)
+	}()
+	return hookStop, exhausted
+}
+
+func (w *Watcher) addHook(h hook) {
+	w.mu.Lock()
+	w.shutdownHooks = append(w.shutdownHooks, h)
+	w.mu.Unlock()
+}
+
+// RemoveHook removes the first registered hook with the given name, as reported by
+// `Hooks`, returning whether there was one. Pre-drain, critical, shutdown and final
+// hooks are searched in that order. Once shutdown has begun the hooks are left alone
+// and false is returned.
+func (w *Watcher) RemoveHook(name string) bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.shuttingDown || w.preDraining {
+		return false
+	}
+	var removed bool
+	if w.preDrainHooks, removed = removeShutdownHook(w.preDrainHooks, name); removed {
+		return true
+	}
+	if w.criticalHooks, removed = removeShutdownHook(w.criticalHooks, name); removed {
+		return true
+	}
+	for i, h := range w.shutdownHooks {
+		if h.name == name {
+			w.shutdownHooks = append(w.shutdownHooks[:i:i], w.shutdownHooks[i+1:]...)
+			return true
+		}
+	}
+	for i, f := range w.finalHooks {
+		if funcName(f) == name {
+			w.finalHooks = append(w.finalHooks[:i:i], w.finalHooks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// removeShutdownHook returns fs without the first hook named name, and whether there
+// was one.
+func removeShutdownHook(fs []ShutdownHook, name string) ([]ShutdownHook, bool) {
+	for i, f := range fs {
+		if funcName(f) == name {
+			return append(fs[:i:i], fs[i+1:]...), true
+		}
+	}
+	return fs, false
+}
+
+// hookSnapshot returns a snapshot of the registered hooks so they can be run without
+// holding the lock.
+func (w *Watcher) hookSnapshot() []hook {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	hooks := make([]hook, len(w.shutdownHooks))
+	copy(hooks, w.shutdownHooks)
+	return hooks
+}
+
+// Hooks returns the names of the registered hooks in the order they will run. Hooks
+// registered without a name are named after their function.
+func (w *Watcher) Hooks() []string {
+	if w == nil {
+		return nil
+	}
+	names := append(w.preDrainHookNames(), w.criticalHookNames()...)
+	for _, batch := range hookBatches(w.hookSnapshot()) {
+		for _, h := range batch {
+			names = append(names, h.name)
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, f := range w.finalHooks {
+		names = append(names, funcName(f))
+	}
+	return names
+}
+
+func (w *Watcher) setHookResults(results []HookResult) {
+	w.mu.Lock()
+	w.hookResults = results
+	w.mu.Unlock()
+}
+
+// LastHookResults returns the outcome of each hook from the most recent call to
+// `RunHooks` or `RunHooksGroup`, in registration order. It is empty if hooks have
+// not been run.
+func (w *Watcher) LastHookResults() []HookResult {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	results := make([]HookResult, len(w.hookResults))
+	copy(results, w.hookResults)
+	return results
+}
+
+// RunHooksGroup executes registered hooks concurrently. The first hook to fail
+// cancels the context passed to the others, and its error is returned once all
+// hooks have returned. Cancelable hooks see the same cancellation through their
+// channel, while hooks registered as `ShutdownHook` run to completion.
+func (w *Watcher) RunHooksGroup(ctx context.Context) error {
+	if w == nil {
+		return errors.New("RunHooksGroup: receiver is nil")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	hooks := w.hookSnapshot()
+	w.mu.Lock()
+	limit := w.hookConcurrency
+	w.mu.Unlock()
+	var sem chan struct{}
+	if limit > 0 {
+		sem = make(chan struct{}, limit)
+	}
+	results := make([]HookResult, len(hooks))
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i, h := range hooks {
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		wg.Add(1)
+		go func(i int, h hook) {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			results[i] = h.runTimed(ctx, ctx.Done())
+			if results[i].Err != nil {
+				once.Do(func() {
+					firstErr = results[i].Err
+					cancel()
+				})
+			}
+		}(i, h)
+	}
+	wg.Wait()
+	w.setHookResults(results)
+	return firstErr
+}
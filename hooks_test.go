@@ -0,0 +1,490 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunHooksGroup(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestRunHooksGroup: should not be nil")
+	}
+	failErr := errors.New("fail fast")
+	cancelled := make(chan bool, 1)
+	_ = w.AddContextHook(func(ctx context.Context) error {
+		return failErr
+	})
+	_ = w.AddContextHook(func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			cancelled <- true
+			return ctx.Err()
+		case <-time.After(3 * time.Second):
+			cancelled <- false
+			return nil
+		}
+	})
+	err := w.RunHooksGroup(context.Background())
+	if err != failErr {
+		t.Errorf("TestRunHooksGroup: should return the first error, got %v", err)
+	}
+	if !<-cancelled {
+		t.Errorf("TestRunHooksGroup: second hook should have observed cancellation")
+	}
+}
+
+func TestRunHooksGroupNoError(t *testing.T) {
+	w, wErr := NewWatcher(3000, sampleShutdownHook)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestRunHooksGroupNoError: should not be nil")
+	}
+	ran := make(chan bool, 1)
+	_ = w.AddContextHook(func(ctx context.Context) error {
+		ran <- true
+		return nil
+	})
+	err := w.RunHooksGroup(context.Background())
+	if err != nil {
+		t.Errorf("TestRunHooksGroupNoError: should not have error")
+	}
+	if !<-ran {
+		t.Errorf("TestRunHooksGroupNoError: hook should have run")
+	}
+}
+
+func slowShutdownHook() error {
+	time.Sleep(200 * time.Millisecond)
+	return nil
+}
+
+func failingShutdownHook() error {
+	return errors.New("failing hook")
+}
+
+func TestLastHookResults(t *testing.T) {
+	w, wErr := NewWatcher(3000, slowShutdownHook, failingShutdownHook)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestLastHookResults: should not be nil")
+	}
+	if len(w.LastHookResults()) != 0 {
+		t.Errorf("TestLastHookResults: should have no results before hooks run")
+	}
+	err := w.RunHooks()
+	if err == nil {
+		t.Errorf("TestLastHookResults: should have error from failing hook")
+	}
+	results := w.LastHookResults()
+	if len(results) != 2 {
+		t.Fatalf("TestLastHookResults: should have 2 results, got %d", len(results))
+	}
+	if !strings.HasSuffix(results[0].Name, "slowShutdownHook") || results[0].Err != nil {
+		t.Errorf("TestLastHookResults: unexpected first result %+v", results[0])
+	}
+	if results[0].Duration < 200*time.Millisecond || results[0].Duration > time.Second {
+		t.Errorf("TestLastHookResults: slow hook duration %v out of range", results[0].Duration)
+	}
+	if !strings.HasSuffix(results[1].Name, "failingShutdownHook") || results[1].Err == nil {
+		t.Errorf("TestLastHookResults: unexpected second result %+v", results[1])
+	}
+	if results[1].Duration > 100*time.Millisecond {
+		t.Errorf("TestLastHookResults: failing hook duration %v out of range", results[1].Duration)
+	}
+}
+
+func panickingShutdownHook() error {
+	var c chan int
+	close(c)
+	return nil
+}
+
+func TestRunHooksPanic(t *testing.T) {
+	afterRan := false
+	w, wErr := NewWatcher(3000, panickingShutdownHook, func() error {
+		afterRan = true
+		return nil
+	})
+	if w == nil || wErr != nil {
+		t.Fatalf("TestRunHooksPanic: should not be nil")
+	}
+	err := w.RunHooks()
+	if err == nil || !strings.Contains(err.Error(), "panicked") {
+		t.Errorf("TestRunHooksPanic: should report the panic, got %v", err)
+	}
+	if !afterRan {
+		t.Errorf("TestRunHooksPanic: later hooks should still run")
+	}
+	results := w.LastHookResults()
+	if len(results) != 2 || results[0].Err == nil || results[1].Err != nil {
+		t.Errorf("TestRunHooksPanic: panic should be attributed to the first hook: %+v", results)
+	}
+	if !strings.Contains(results[0].Err.Error(), "panickingShutdownHook") {
+		t.Errorf("TestRunHooksPanic: error should name the hook: %v", results[0].Err)
+	}
+}
+
+// loopUntilStop is a cancelable hook that works until told to stop.
+func loopUntilStop(returned chan<- bool) CancelableHook {
+	return func(stop <-chan struct{}) error {
+		for {
+			select {
+			case <-stop:
+				returned <- true
+				return nil
+			default:
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+}
+
+func TestCancelableHookGraceExpires(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000)
+	returned := make(chan bool, 1)
+	_ = w.AddCancelableHook(loopUntilStop(returned))
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	// No conns, so the hook starts right away and runs until the grace period ends.
+	clock.BlockUntil(1)
+	select {
+	case <-returned:
+		t.Fatalf("TestCancelableHookGraceExpires: hook should still be running")
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.Advance(3 * time.Second)
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatalf("TestCancelableHookGraceExpires: hook should return when the grace period expires")
+	}
+	err := <-errChan
+	if err != nil {
+		t.Errorf("TestCancelableHookGraceExpires: drain did not time out, should not have error: %v", err)
+	}
+}
+
+func TestCancelableHookAfterTimeout(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000)
+	returned := make(chan bool, 1)
+	_ = w.AddCancelableHook(loopUntilStop(returned))
+	w.RecordConn(true) // never closed
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(3 * time.Second)
+	select {
+	case err := <-errChan:
+		if err == nil {
+			t.Errorf("TestCancelableHookAfterTimeout: should have timeout error")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestCancelableHookAfterTimeout: hook should return promptly after a timeout")
+	}
+	if !<-returned {
+		t.Errorf("TestCancelableHookAfterTimeout: hook should have observed stop")
+	}
+}
+
+func TestRetryableHook(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestRetryableHook: should not be nil")
+	}
+	calls := 0
+	flaky := func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("flaky")
+		}
+		return nil
+	}
+	err := w.AddRetryableHook(flaky, 3, time.Millisecond)
+	if err != nil {
+		t.Fatalf("TestRetryableHook: should not have error")
+	}
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestRetryableHook: should succeed on the third attempt: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("TestRetryableHook: should have been called 3 times, got %d", calls)
+	}
+
+	calls = 0
+	err = w.RunHooks()
+	if err != nil {
+		t.Errorf("TestRetryableHook: RunHooks should retry too: %v", err)
+	}
+	if w.AddRetryableHook(flaky, 0, time.Millisecond) == nil {
+		t.Errorf("TestRetryableHook: zero attempts should be rejected")
+	}
+}
+
+func TestRetryableHookGivesUp(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000)
+	calls := 0
+	err := w.AddRetryableHook(func() error {
+		calls++
+		return errors.New("always fails")
+	}, 5, time.Minute)
+	if err != nil {
+		t.Fatalf("TestRetryableHookGivesUp: should not have error")
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	// The grace period timer, then the first backoff.
+	clock.BlockUntil(2)
+	clock.Advance(3 * time.Second)
+	err = <-errChan
+	if err == nil || !strings.Contains(err.Error(), "always fails") {
+		t.Errorf("TestRetryableHookGivesUp: should report the last error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("TestRetryableHookGivesUp: should stop retrying once the grace period expires, got %d calls", calls)
+	}
+}
+
+func TestFailFastHooks(t *testing.T) {
+	ran := make([]bool, 3)
+	w, wErr := NewWatcher(1000,
+		func() error {
+			ran[0] = true
+			return errors.New("critical hook failed")
+		},
+		func() error {
+			ran[1] = true
+			return nil
+		},
+		func() error {
+			ran[2] = true
+			return nil
+		})
+	if w == nil || wErr != nil {
+		t.Fatalf("TestFailFastHooks: should not be nil")
+	}
+	err := w.Configure(WithFailFastHooks(true))
+	if err != nil {
+		t.Fatalf("TestFailFastHooks: should not have error")
+	}
+	err = w.OnStop()
+	if err == nil || !strings.Contains(err.Error(), "critical hook failed") {
+		t.Errorf("TestFailFastHooks: should return the first error, got %v", err)
+	}
+	if !ran[0] || ran[1] || ran[2] {
+		t.Errorf("TestFailFastHooks: only the first hook should run, got %v", ran)
+	}
+	if len(w.LastHookResults()) != 1 {
+		t.Errorf("TestFailFastHooks: skipped hooks should have no result")
+	}
+
+	err = w.Configure(WithFailFastHooks(false))
+	if err != nil {
+		t.Fatalf("TestFailFastHooks: should not have error")
+	}
+	err = w.RunHooks()
+	if err == nil || !ran[1] || !ran[2] {
+		t.Errorf("TestFailFastHooks: all hooks should run by default")
+	}
+}
+
+func TestHooks(t *testing.T) {
+	w, wErr := NewWatcher(1000, sampleShutdownHook)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestHooks: should not be nil")
+	}
+	_ = w.AddNamedHook("flush-metrics", func() error { return nil })
+	_ = w.AddContextHook(func(ctx context.Context) error { return nil })
+	_ = w.AddNamedHook("close-db", sampleShutdownHook)
+	if w.AddNamedHook("", sampleShutdownHook) == nil {
+		t.Errorf("TestHooks: empty name should be rejected")
+	}
+
+	names := w.Hooks()
+	if len(names) != 4 {
+		t.Fatalf("TestHooks: should have 4 hooks, got %v", names)
+	}
+	if !strings.HasSuffix(names[0], ".sampleShutdownHook") {
+		t.Errorf("TestHooks: unnamed hook should use its function name, got %s", names[0])
+	}
+	if names[1] != "flush-metrics" || names[3] != "close-db" {
+		t.Errorf("TestHooks: named hooks should keep their names, got %v", names)
+	}
+	if !strings.Contains(names[2], "TestHooks") {
+		t.Errorf("TestHooks: unnamed closure should be named after its function, got %s", names[2])
+	}
+
+	_ = w.RunHooks()
+	for i, result := range w.LastHookResults() {
+		if result.Name != names[i] {
+			t.Errorf("TestHooks: result %d should be named %s, got %s", i, names[i], result.Name)
+		}
+	}
+}
+
+func TestHookBudget(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestHookBudget: should not be nil")
+	}
+	err := w.Configure(WithHookBudget(100 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("TestHookBudget: should not have error")
+	}
+	ran := make([]bool, 3)
+	for i, name := range []string{"first", "second", "third"} {
+		i := i
+		_ = w.AddNamedHook(name, func() error {
+			ran[i] = true
+			time.Sleep(60 * time.Millisecond)
+			return nil
+		})
+	}
+	err = w.OnStop()
+	if !errors.Is(err, ErrHookBudgetExceeded) {
+		t.Fatalf("TestHookBudget: should exceed the budget, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "did not run: third") {
+		t.Errorf("TestHookBudget: should list the unrun hooks, got %v", err)
+	}
+	if !ran[0] || !ran[1] || ran[2] {
+		t.Errorf("TestHookBudget: only the first two hooks should run, got %v", ran)
+	}
+	if len(w.LastHookResults()) != 2 {
+		t.Errorf("TestHookBudget: skipped hooks should have no result")
+	}
+}
+
+func TestHookBudgetStopsCancelableHook(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000)
+	err := w.Configure(WithHookBudget(time.Second))
+	if err != nil {
+		t.Fatalf("TestHookBudgetStopsCancelableHook: should not have error")
+	}
+	returned := make(chan bool, 1)
+	_ = w.AddCancelableHook(loopUntilStop(returned))
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.RunHooks()
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	err = <-errChan
+	if err != nil {
+		t.Errorf("TestHookBudgetStopsCancelableHook: should not have error: %v", err)
+	}
+	select {
+	case <-returned:
+	default:
+		t.Errorf("TestHookBudgetStopsCancelableHook: hook should see the budget expire")
+	}
+}
+
+func TestFinalHook(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000, sampleShutdownHook)
+	stats := make(chan ShutdownStats, 1)
+	err := w.AddFinalHook(func(s ShutdownStats) error {
+		stats <- s
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("TestFinalHook: should not have error")
+	}
+	w.RecordConn(true)
+	w.RecordConn(true) // hangs past the timeout
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	w.RecordConn(false)
+	clock.Advance(3 * time.Second)
+	<-errChan
+	s := <-stats
+	if !s.TimedOut || s.Cancelled || s.StartConns != 2 || s.DrainedConns != 1 || s.RemainingConns != 1 {
+		t.Errorf("TestFinalHook: unexpected stats %+v", s)
+	}
+	if s.Duration != 3*time.Second {
+		t.Errorf("TestFinalHook: should report the duration, got %v", s.Duration)
+	}
+	if names := w.Hooks(); len(names) != 2 || !strings.Contains(names[1], "TestFinalHook") {
+		t.Errorf("TestFinalHook: final hook should be listed last, got %v", names)
+	}
+	if results := w.LastHookResults(); len(results) != 2 {
+		t.Errorf("TestFinalHook: final hook should be in the results, got %d", len(results))
+	}
+	if w.AddFinalHook(nil) == nil {
+		t.Errorf("TestFinalHook: nil hook should be rejected")
+	}
+}
+
+func TestRemoveHook(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestRemoveHook: should not be nil")
+	}
+	ran := make(map[string]bool)
+	for _, name := range []string{"keep", "plugin", "late"} {
+		name := name
+		_ = w.AddNamedHook(name, func() error {
+			ran[name] = true
+			return nil
+		})
+	}
+	if !w.RemoveHook("plugin") {
+		t.Errorf("TestRemoveHook: should remove a registered hook")
+	}
+	if w.RemoveHook("plugin") || w.RemoveHook("missing") {
+		t.Errorf("TestRemoveHook: should not remove an unregistered hook")
+	}
+	if names := w.Hooks(); len(names) != 2 || names[0] != "keep" || names[1] != "late" {
+		t.Errorf("TestRemoveHook: unexpected hooks %v", names)
+	}
+	err := w.OnStop()
+	if err != nil {
+		t.Errorf("TestRemoveHook: should not have error: %v", err)
+	}
+	if !ran["keep"] || ran["plugin"] || !ran["late"] {
+		t.Errorf("TestRemoveHook: removed hook should not run, got %v", ran)
+	}
+	if w.RemoveHook("keep") {
+		t.Errorf("TestRemoveHook: should not remove hooks once shutdown has begun")
+	}
+}
+
+func TestRemoveHookPhases(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestRemoveHookPhases: should not be nil")
+	}
+	ran := false
+	_ = w.AddPreDrainHook(func() error { ran = true; return nil })
+	_ = w.AddCriticalHook(func() error { ran = true; return nil })
+	_ = w.AddFinalHook(func(ShutdownStats) error { ran = true; return nil })
+	names := w.Hooks()
+	if len(names) != 3 {
+		t.Fatalf("TestRemoveHookPhases: should list 3 hooks, got %v", names)
+	}
+	for _, name := range names {
+		if !w.RemoveHook(name) {
+			t.Errorf("TestRemoveHookPhases: should remove %s", name)
+		}
+	}
+	if names := w.Hooks(); len(names) != 0 {
+		t.Errorf("TestRemoveHookPhases: should have no hooks left, got %v", names)
+	}
+	if err := w.OnStop(); err != nil {
+		t.Errorf("TestRemoveHookPhases: should not have error: %v", err)
+	}
+	if ran {
+		t.Errorf("TestRemoveHookPhases: removed hooks should not run")
+	}
+}
@@ -7,45 +7,169 @@
 package httpdshutdown
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
+// NoTimeout can be passed to `NewWatcher` or `NewWatcherDuration` in place of a
+// timeout to make `OnStop` wait for open connections to close no matter how long
+// that takes.
+const NoTimeout = -1
+
 // ShutdownHook is the type callers will implement in their own daemon shutdown handlers.
 type ShutdownHook func() error
 
 // Watcher manages the execution of shutdownHooks.
 type Watcher struct {
-	connsWG       *sync.WaitGroup // Allows us to wait for conns to complete.
-	shutdownHooks []ShutdownHook  // Run these when daemon is done or timed out.
-	timeoutMS     int             // Grace period for daemon shutdown.
+	timeout time.Duration // Grace period for daemon shutdown, zero for NoTimeout. Fixed at construction.
+
+	// mu guards all of the fields below, since connection state callbacks, signal
+	// handlers and status handlers all run on their own goroutines.
+	mu               sync.Mutex
+	activeConns      atomic.Int64          // Connections opened but not yet closed, also updated without mu.
+	peakConns        atomic.Int64          // High-water mark of activeConns since construction or Reset.
+	fastPath         atomic.Bool           // Conns may be counted without mu, see countConnFast.
+	tlsConns         int64                 // The subset of activeConns that use TLS.
+	rejectedConns    int64                 // New conns opened or refused while accepting was off.
+	unbalancedCloses int64                 // Closes seen with no conn open, see Validate.
+	stateViolations  int64                 // Illegal conn state transitions, see WithStrictStateValidation.
+	lastViolation    string                // Description of the last illegal transition.
+	inFlight         int64                 // Requests inside a TrackHandler handler.
+	workers          int64                 // Background workers, see WorkerStarted.
+	connsCond        *sync.Cond            // Signalled when activeConns, inFlight or workers drops, uses mu.
+	conns            map[net.Conn]connInfo // IDs and open times of open conns, see ConnContext.
+	connSeq          int64                 // Last connection ID handed out.
+	maxConns         int64                 // Cap enforced by TryAccept, zero for none.
+	drainThreshold   int64                 // OnStop completes once this many conns remain.
+	minDrain         time.Duration         // OnStop waits at least this long, see WithMinDrainDuration.
+	hardDeadline     time.Duration         // OnStop gives up after this long, see WithHardDeadline.
+	hookBudget       time.Duration         // Total time hooks may run, see WithHookBudget.
+	hookConcurrency  int                   // Most hooks run at once, zero for no limit.
+	shutdownHooks    []hook                // Run these when daemon is done or timed out.
+	preDrainHooks    []ShutdownHook        // Run before the drain, see AddPreDrainHook.
+	criticalHooks    []ShutdownHook        // Always run, see AddCriticalHook.
+	coordinator      DrainCoordinator      // Set with WithDrainCoordinator.
+	finalHooks       []FinalHook           // Run after shutdownHooks, see AddFinalHook.
+	servers          []*http.Server        // Shut down by OnStop, see ManageServer.
+	stoppers         []GracefulStopper     // Registered with ManageGracefulStopper.
+	listeners        []net.Listener        // Registered with ManageListener.
+	children         []*Watcher            // Registered with AddChild.
+	clock            Clock                 // Source of time for the grace period.
+	logger           *log.Logger           // Optional, set with WithLogger.
+	logInterval      time.Duration         // Minimum time between conn state log lines.
+	lastLogged       time.Time             // When the last conn state line was logged.
+	suppressedLogs   int                   // Conn state lines dropped since lastLogged.
+	diagnostics      io.Writer             // Optional, set with WithDiagnosticsWriter.
+
+	progressInterval  time.Duration         // How often progressFn is called during a drain.
+	progressFn        func(remaining int64) // Optional drain progress callback.
+	progressJitter    float64               // Fraction by which the progress interval is randomly varied.
+	closedDuringDrain func(remaining int64) // Optional, see OnConnClosedDuringDrain.
+	drainCloses       []int64               // Pending closedDuringDrain calls, see unlockAndNotify.
+	quiescedFn        func()                // Optional, see OnQuiesced.
+	quiesced          bool                  // Set once no conns are open during shutdown.
+	pendingQuiesced   bool                  // quiescedFn is due, see unlockAndNotify.
+	acceptingFn       func(bool)            // Set with OnAcceptingChanged.
+	acceptingChanges  []bool                // Flips of accepting not yet passed to acceptingFn.
+	draining          bool                  // Set while OnStop waits for conns to drain.
+	drainSubs         []chan int64          // Returned by DrainUpdates.
+
+	accepting      bool                       // Set by the caller once the daemon is serving.
+	acceptingChan  chan struct{}              // Closed while accepting, see WaitForReady.
+	rejecting      bool                       // Set once accepting is turned off, see setAcceptingLocked.
+	everAccepted   bool                       // Set the first time accepting is turned on.
+	ready          bool                       // Set with SetReady once the daemon has warmed up.
+	shuttingDown   bool                       // Set when OnStop begins.
+	preDraining    bool                       // OnStop is running pre-drain hooks.
+	cancelled      bool                       // The last OnStop was aborted with Cancel.
+	lastTimedOut   bool                       // The last OnStop drain timed out.
+	lastDrain      time.Duration              // How long the last OnStop drain took.
+	cancelChan     chan struct{}              // Closed by Cancel to abort an in-progress drain.
+	stopping       int                        // OnStop calls running, from before the pre-drain hooks until they return.
+	closeChan      chan struct{}              // Closed by Close, see closedChanLocked.
+	closed         bool                       // Set by Close.
+	stopDone       chan struct{}              // Closed when the in-progress OnStop returns.
+	graceDeadline  time.Time                  // When the in-progress OnStop grace period ends, zero for none.
+	doneChan       chan struct{}              // Closed once OnStop completes, see Done.
+	done           bool                       // Set once doneChan is closed.
+	hookResults    []HookResult               // Outcome of the most recent hook run.
+	sigHandlers    map[os.Signal]func() error // Registered with OnSignal.
+	exitCodes      map[os.Signal]int          // Set with WithSignalExitCodes.
+	failureCode    int                        // Set with WithFailureExitCode, zero means 1.
+	ignoredSignals map[os.Signal]struct{}     // Set with WithIgnoredSignals.
+
+	gracefulInterrupt bool                         // Treat SIGINT like SIGTERM instead of panicking.
+	twoPhase          bool                         // Stop accepting on the first signal, drain on the second.
+	failFastHooks     bool                         // Stop running hooks after the first failure.
+	connStateLogging  bool                         // Log every recorded conn state.
+	strictStates      bool                         // Check conn state transitions, see WithStrictStateValidation.
+	trackHijacked     bool                         // Keep hijacked conns counted, see WithHijackTracking.
+	connStateMapping  map[http.ConnState]ConnDelta // Set with WithConnStateMapping, nil for the default.
+	dryRun            bool                         // Log what OnStop would do instead of doing it.
+	closeIdle         bool                         // Close idle keep-alive conns when OnStop begins.
+	maxLifetime       time.Duration                // Close conns older than this, see WithMaxConnLifetime.
+	expiryStop        chan struct{}                // Closed to stop expireConns, nil when it is not running.
+	systemdNotify     bool                         // Notify systemd of readiness and shutdown.
 }
 
 // NewWatcher construct a Watcher with a timeout and an optional set of shutdown hooks
-// to be called at the time of shutdown.
+// to be called at the time of shutdown. It is `NewWatcherDuration` with the timeout
+// given in milliseconds, and is kept for compatibility.
 //
 // The first argument is a timeout in milliseconds that will trigger shutdown hooks
-// even if the daemon still has open connections. Further arguments are a variadic
-// list of type `ShutDownHook`.
+// even if the daemon still has open connections. It must be positive, or `NoTimeout`
+// to wait for connections however long they take; zero is rejected rather than
+// treated as "no grace period" or "unlimited". Further arguments are a variadic
+// list of type `ShutDownHook`, none of which may be nil.
 //
 // Example instantiation:
 //
-//     watcher, watcher_err := httpdshutdown.NewWatcher(2000, sampleShutdownHook1, sampleShutdownHook2)
-//
+//	watcher, watcher_err := httpdshutdown.NewWatcher(2000, sampleShutdownHook1, sampleShutdownHook2)
 func NewWatcher(timeoutMS int, hooks ...ShutdownHook) (*Watcher, error) {
-	if timeoutMS < 0 {
+	if timeoutMS == NoTimeout {
+		return NewWatcherDuration(NoTimeout, hooks...)
+	}
+	return NewWatcherDuration(time.Duration(timeoutMS)*time.Millisecond, hooks...)
+}
+
+// NewWatcherDuration constructs a Watcher with a timeout and an optional set of
+// shutdown hooks to be called at the time of shutdown. The timeout follows the same
+// rules as for `NewWatcher`: it must be positive, or `NoTimeout`.
+//
+// Example instantiation:
+//
+//	watcher, err := httpdshutdown.NewWatcherDuration(2*time.Second, sampleShutdownHook1)
+func NewWatcherDuration(timeout time.Duration, hooks ...ShutdownHook) (*Watcher, error) {
+	if timeout <= 0 && timeout != NoTimeout {
+		// A zero timeout would fire immediately and skip the graceful drain entirely.
 		return nil, errors.New("timeout must be a positive number")
 	}
 	w := new(Watcher)
-	w.timeoutMS = timeoutMS
-	w.connsWG = new(sync.WaitGroup)
-	w.shutdownHooks = make([]ShutdownHook, len(hooks))
-	copy(w.shutdownHooks, hooks)
+	if timeout != NoTimeout {
+		w.timeout = timeout
+	}
+	w.clock = realClock{}
+	w.connsCond = sync.NewCond(&w.mu)
+	w.updateFastPathLocked()
+	w.shutdownHooks = make([]hook, len(hooks))
+	for i, f := range hooks {
+		if f == nil {
+			// Calling it would panic deep in shutdown, where it is hardest to debug.
+			return nil, fmt.Errorf("shutdown hook %d is nil", i)
+		}
+		w.shutdownHooks[i] = hook{name: funcName(f), run: f.hookFunc()}
+	}
 	return w, nil
 }
 
@@ -54,69 +178,574 @@ func NewWatcher(timeoutMS int, hooks ...ShutdownHook) (*Watcher, error) {
 //
 // Example use:
 //
-//    srv := &http.Server{
-//            Addr: ":8080",
-//            ReadTimeout:  3 * time.Second,
-//            WriteTimeout: 3 * time.Second,
-//            ConnState: func(conn net.Conn, newState http.ConnState) {
-//                    log.Printf("(1) NEW CONN STATE:%v\n", newState)
-//                    watcher.RecordConnState(newState)
-//            }
-//    }
-//
+//	srv := &http.Server{
+//	        Addr: ":8080",
+//	        ReadTimeout:  3 * time.Second,
+//	        WriteTimeout: 3 * time.Second,
+//	        ConnState: func(conn net.Conn, newState http.ConnState) {
+//	                log.Printf("(1) NEW CONN STATE:%v\n", newState)
+//	                watcher.RecordConnState(newState)
+//	        }
+//	}
 func (w *Watcher) RecordConnState(newState http.ConnState) {
 	if w == nil {
-		// we panic here instead of returning nil as the calling context does not
-		// do any error checking
-		panic("RecordConnState: receiver is nil")
+		nilReceiver("RecordConnState")
+		return
+	}
+	if w.countConnFast(newState) {
+		return
+	}
+	w.mu.Lock()
+	defer w.unlockAndNotify()
+	defer w.logConnStateLocked("RecordConnState", newState)
+	switch w.connDeltaLocked(newState) {
+	case ConnOpen:
+		w.connOpenedLocked()
+	case ConnDone:
+		w.connClosedLocked()
+	}
+}
+
+// ConnOpened counts a newly opened connection. Together with `ConnClosed` it is the
+// counting core behind `RecordConnState`, for setups that see connections before,
+// or instead of, an `http.Server`'s `ConnState` callback, such as a wrapping
+// `net.Listener`. Once accepting has been turned off the connection is also counted
+// in `RejectedConns`.
+func (w *Watcher) ConnOpened() {
+	if w == nil {
+		nilReceiver("ConnOpened")
+		return
+	}
+	if w.countConnFast(http.StateNew) {
+		return
 	}
-	switch newState {
-	case http.StateNew:
-		w.connsWG.Add(1)
-	case http.StateClosed, http.StateHijacked:
-		w.connsWG.Done()
+	w.mu.Lock()
+	defer w.unlockAndNotify()
+	w.connOpenedLocked()
+}
+
+// ConnClosed counts a closed connection opened with `ConnOpened`. Unbalanced calls
+// leave the count at zero rather than letting it go negative.
+func (w *Watcher) ConnClosed() {
+	if w == nil {
+		nilReceiver("ConnClosed")
+		return
+	}
+	if w.countConnFast(http.StateClosed) {
+		return
+	}
+	w.mu.Lock()
+	defer w.unlockAndNotify()
+	w.connClosedLocked()
+}
+
+// connOpenedLocked implements `ConnOpened`. w.mu must be held.
+func (w *Watcher) connOpenedLocked() {
+	if w.rejecting {
+		w.rejectedConns++
+	}
+	w.addConnLocked()
+}
+
+// connClosedLocked implements `ConnClosed`. w.mu must be held.
+func (w *Watcher) connClosedLocked() {
+	w.removeConnLocked()
+}
+
+// RecordConn counts an opened (`open` is true) or closed (`open` is false) connection,
+// for daemons that serve a raw `net.Listener`. Unlike `ConnOpened`, it counts the
+// connection even once accepting has been turned off.
+//
+// Example use:
+//
+//	conn, err := ln.Accept()
+//	...
+//	watcher.RecordConn(true)
+//	go func() {
+//	        defer watcher.RecordConn(false)
+//	        handle(conn)
+//	}()
+func (w *Watcher) RecordConn(open bool) {
+	if w == nil {
+		nilReceiver("RecordConn")
+		return
+	}
+	w.mu.Lock()
+	defer w.unlockAndNotify()
+	if open {
+		w.addConnLocked()
+		return
+	}
+	w.removeConnLocked()
+}
+
+// addConnLocked counts a new connection. w.mu must be held.
+func (w *Watcher) addConnLocked() {
+	w.raisePeak(w.activeConns.Add(1))
+	w.connAddedLocked()
+}
+
+// connAddedLocked reports a connection counted by addConnLocked or countConnFast.
+// w.mu must be held.
+func (w *Watcher) connAddedLocked() {
+	w.sendDrainUpdateLocked()
+}
+
+// removeConnLocked counts a closed connection. w.mu must be held.
+func (w *Watcher) removeConnLocked() {
+	if !w.decrementConns() {
+		// Unbalanced calls are a caller bug, but panicking in a connection
+		// callback would take the daemon down, so the count just stays at zero
+		// and Validate reports it.
+		w.unbalancedCloses++
+		return
 	}
+	w.connRemovedLocked()
+}
+
+// connRemovedLocked reports a close counted by removeConnLocked or countConnFast.
+// w.mu must be held.
+func (w *Watcher) connRemovedLocked() {
+	w.connsCond.Broadcast()
+	w.sendDrainUpdateLocked()
+	if w.shuttingDown && w.closedDuringDrain != nil {
+		w.drainCloses = append(w.drainCloses, w.activeConns.Load())
+	}
+	w.checkQuiescedLocked()
+}
+
+// setAcceptingLocked updates the accepting flag. Once accepting has been turned off,
+// new connections are counted in `RejectedConns` as well, and `TryAccept` refuses
+// them. w.mu must be held.
+func (w *Watcher) setAcceptingLocked(accepting bool) {
+	if accepting != w.accepting {
+		w.acceptingChangedLocked(accepting)
+	}
+	if accepting != w.accepting && w.acceptingChan != nil {
+		if accepting {
+			close(w.acceptingChan)
+		} else {
+			w.acceptingChan = nil
+		}
+	}
+	w.accepting = accepting
+	w.everAccepted = w.everAccepted || accepting
+	w.rejecting = !accepting
+	w.updateFastPathLocked()
+	if accepting {
+		w.startExpiryLocked()
+	}
+}
+
+// TryAccept records an opened connection and returns true, unless the cap set with
+// `WithMaxConns` has been reached, in which case nothing is recorded and false is
+// returned so the caller can reject the connection. A connection admitted by
+// `TryAccept` is released with `RecordConn(false)`.
+//
+// Example use:
+//
+//	conn, err := ln.Accept()
+//	...
+//	if !watcher.TryAccept() {
+//	        conn.Close()
+//	        continue
+//	}
+//	go func() {
+//	        defer watcher.RecordConn(false)
+//	        handle(conn)
+//	}()
+func (w *Watcher) TryAccept() bool {
+	if w == nil {
+		nilReceiver("TryAccept")
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.rejecting {
+		w.rejectedConns++
+		return false
+	}
+	if w.maxConns > 0 && w.activeConns.Load() >= w.maxConns {
+		return false
+	}
+	w.addConnLocked()
+	return true
+}
+
+// ActiveConns returns the number of connections currently open, as recorded
+// by `RecordConnState`.
+func (w *Watcher) ActiveConns() int64 {
+	if w == nil {
+		return 0
+	}
+	return w.activeConns.Load()
+}
+
+// PeakConns returns the largest number of connections that were open at once since
+// the Watcher was constructed or last `Reset`.
+func (w *Watcher) PeakConns() int64 {
+	if w == nil {
+		return 0
+	}
+	return w.peakConns.Load()
+}
+
+// Accepting marks whether the daemon is currently accepting new connections.
+// Callers typically set this to true once their listener is up. `OnStop` sets
+// it back to false. While the daemon is marked as not accepting, `TryAccept` refuses
+// new connections, and those the server accepts anyway are still counted, so a drain
+// waits for them; see `RejectedConns`.
+//
+// While an `OnStop` is in progress and has not been cancelled, `Accepting(true)` is
+// ignored, as a drain must not take new connections. Once an `OnStop` aborted with
+// `Cancel` has returned, `Accepting(true)` resumes normal operation: the shutting
+// down flag is cleared and `Done` waits for the next shutdown, so a false-alarm
+// shutdown need not end the daemon.
+func (w *Watcher) Accepting(accepting bool) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	if accepting && w.stopDone != nil && !w.cancelled {
+		w.mu.Unlock()
+		return
+	}
+	if accepting && w.cancelled && w.stopDone == nil {
+		w.resumeLocked()
+	}
+	w.setAcceptingLocked(accepting)
+	w.unlockAndNotify()
+}
+
+// RejectedConns returns the number of new connections that were opened, or were
+// refused by `TryAccept`, while the daemon was not accepting. It is a metric only:
+// conns opened then are counted in `ActiveConns` like any other, so a drain waits
+// for them.
+func (w *Watcher) RejectedConns() int64 {
+	if w == nil {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rejectedConns
+}
+
+// OnAcceptingChanged registers fn to be called whenever the accepting flag flips,
+// whether through `Accepting`, `OnStop` or a signal, with its new value. This lets
+// the daemon update external routing as soon as it stops accepting rather than
+// polling `IsAccepting`. fn is called after the Watcher's lock is released, in the
+// order the flips happened.
+//
+// Example use:
+//
+//	err := watcher.OnAcceptingChanged(func(accepting bool) {
+//	        routes.SetEnabled(instanceID, accepting)
+//	})
+func (w *Watcher) OnAcceptingChanged(fn func(accepting bool)) error {
+	if w == nil {
+		return errors.New("OnAcceptingChanged: receiver is nil")
+	}
+	if fn == nil {
+		return errors.New("OnAcceptingChanged: callback is nil")
+	}
+	w.mu.Lock()
+	w.acceptingFn = fn
+	w.mu.Unlock()
+	return nil
+}
+
+// acceptingChangedLocked arranges for the `OnAcceptingChanged` callback to be called
+// by `unlockAndNotify` with the new value of the accepting flag. w.mu must be held.
+func (w *Watcher) acceptingChangedLocked(accepting bool) {
+	if w.acceptingFn != nil {
+		w.acceptingChanges = append(w.acceptingChanges, accepting)
+	}
+}
+
+// resumeLocked undoes a cancelled shutdown. w.mu must be held.
+func (w *Watcher) resumeLocked() {
+	w.cancelled = false
+	w.shuttingDown = false
+	w.quiesced = false
+	if w.done {
+		w.doneChan = nil
+		w.done = false
+	}
+}
+
+// IsAccepting reports whether the daemon has been marked as accepting connections.
+func (w *Watcher) IsAccepting() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.accepting
+}
+
+// IsShuttingDown reports whether `OnStop` has been called.
+func (w *Watcher) IsShuttingDown() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.shuttingDown
+}
+
+// LastShutdownTimedOut reports whether the drain of the most recent `OnStop` timed
+// out, including at a `WithHardDeadline` deadline. It stays readable after `OnStop`
+// returns, such as for a crash report, until `Reset`.
+func (w *Watcher) LastShutdownTimedOut() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastTimedOut
+}
+
+// LastDrainDuration returns how long the drain of the most recent `OnStop` took, as
+// `OnStopTimed` reports it, or zero if there has been none since construction or
+// `Reset`.
+func (w *Watcher) LastDrainDuration() time.Duration {
+	if w == nil {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastDrain
+}
+
+// DeadlineExceeded reports whether an `OnStop` is still in progress although its
+// grace period has passed, such as when a hook has stalled. A watchdog can use it to
+// escalate, for example by killing the process. It is always false with `NoTimeout`.
+//
+// Example use:
+//
+//	for range time.Tick(time.Second) {
+//	        if watcher.DeadlineExceeded() {
+//	                log.Fatal("graceful shutdown stalled")
+//	        }
+//	}
+func (w *Watcher) DeadlineExceeded() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !w.graceDeadline.IsZero() && !w.clock.Now().Before(w.graceDeadline)
 }
 
 // RunHooks executes registered hooks, each of which blocks. Typically this is called
-// automatically by `OnStop`.
+// automatically by `OnStop`. The outcome of each hook is available afterwards from
+// `LastHookResults`.
 func (w *Watcher) RunHooks() error {
 	if w == nil {
 		return errors.New("RunHooks: receiver is nil")
 	}
 	errStrs := make([]string, 0)
-	for _, f := range w.shutdownHooks {
-		err := f()
-		if err != nil {
-			errStrs = append(errStrs, "shutdown hook err: "+err.Error())
+	results, budgetErr := w.runHooks(context.Background(), nil)
+	for _, result := range results {
+		if result.Err != nil {
+			errStrs = append(errStrs, "shutdown hook err: "+result.Err.Error())
 		}
 	}
+	if budgetErr != nil {
+		errStrs = append(errStrs, "shutdown hook err: "+budgetErr.Error())
+	}
 	if len(errStrs) != 0 {
 		return errors.New(strings.Join(errStrs, "\n"))
 	}
 	return nil
 }
 
+// runHooks executes registered hooks and records their results: hooks registered
+// without a phase run serially, then each phase runs concurrently, see
+// `AddHookToPhase`. Cancelable hooks are passed stop. With `WithFailFastHooks` the
+// hooks after the first failure are skipped and have no result. The returned error
+// reports hooks skipped because the `WithHookBudget` budget ran out.
+func (w *Watcher) runHooks(ctx context.Context, stop <-chan struct{}) ([]HookResult, error) {
+	batches := hookBatches(w.hookSnapshot())
+	w.mu.Lock()
+	failFast, budget, limit, clock := w.failFastHooks, w.hookBudget, w.hookConcurrency, w.clock
+	w.mu.Unlock()
+	var exhausted <-chan struct{}
+	if budget > 0 {
+		finished := make(chan struct{})
+		defer close(finished)
+		stop, exhausted = budgetStop(clock.After(budget), stop, finished)
+	}
+	results := make([]HookResult, 0)
+	for i, batch := range batches {
+		select {
+		case <-exhausted:
+			unrun := make([]string, 0)
+			for _, batch := range batches[i:] {
+				for _, h := range batch {
+					unrun = append(unrun, h.name)
+				}
+			}
+			w.setHookResults(results)
+			return results, fmt.Errorf("%w, did not run: %s", ErrHookBudgetExceeded, strings.Join(unrun, ", "))
+		default:
+		}
+		batchResults := runBatch(ctx, stop, batch, limit)
+		results = append(results, batchResults...)
+		if failFast && batchFailed(batchResults) {
+			break
+		}
+	}
+	w.setHookResults(results)
+	return results, nil
+}
+
 // OnStop will be called by a daemon's signal handler when it is time to shutdown. If there
 // are any shutdown handlers, they will be called. The timeout set on the watcher will
-// be honored. Typically this is called via `SigHandle` as your signal handler.
+// be honored. Typically this is called via `SigHandle` as your signal handler. Servers
+// passed to `ManageServer` are shut down before any hooks run.
+//
+// If the drain times out or is cancelled, or any hook fails, the returned error is a
+// `*ShutdownError` describing what went wrong.
 func (w *Watcher) OnStop() error {
 	if w == nil {
 		return errors.New("OnStop: receiver is nil")
 	}
-	waitChan := make(chan bool, 1)
-	go func() {
-		w.connsWG.Wait()
-		waitChan <- true
-	}()
-	select {
-	case <-waitChan:
-		_ = w.RunHooks()
-		return nil
-	case <-time.After(time.Duration(w.timeoutMS) * time.Millisecond):
-		_ = w.RunHooks()
-		return errors.New("OnStop: shutdown timed out")
+	return w.stop(stopConfig{ctx: context.Background()})
+}
+
+// OnStopContext is `OnStop` with a context that is passed to context-aware hooks,
+// so values such as tracing spans can be carried through the shutdown sequence. The
+// drain itself is still bounded by the Watcher's timeout and `Cancel`.
+func (w *Watcher) OnStopContext(ctx context.Context) error {
+	if w == nil {
+		return errors.New("OnStopContext: receiver is nil")
+	}
+	return w.stop(stopConfig{ctx: ctx})
+}
+
+// OnStopWithTimeout is `OnStop` with a grace period of d for this call only, in
+// place of the timeout the Watcher was constructed with. This suits drains that
+// warrant more or less time than usual, such as one started by an operator.
+func (w *Watcher) OnStopWithTimeout(d time.Duration) error {
+	if w == nil {
+		return errors.New("OnStopWithTimeout: receiver is nil")
+	}
+	if d <= 0 {
+		return errors.New("OnStopWithTimeout: timeout must be positive")
+	}
+	return w.stop(stopConfig{ctx: context.Background(), timeout: d})
+}
+
+// OnStopDeadline is `OnStop` with the drain bounded by an absolute deadline rather
+// than the Watcher's timeout, for orchestrators that say when the process must be
+// gone rather than how long it has. A deadline that has already passed times the
+// drain out at once.
+//
+// Example use:
+//
+//	err := watcher.OnStopDeadline(time.Now().Add(25 * time.Second))
+func (w *Watcher) OnStopDeadline(deadline time.Time) error {
+	if w == nil {
+		return errors.New("OnStopDeadline: receiver is nil")
+	}
+	w.mu.Lock()
+	remaining := deadline.Sub(w.clock.Now())
+	w.mu.Unlock()
+	if remaining <= 0 {
+		// Zero would mean the Watcher's own timeout.
+		remaining = time.Nanosecond
+	}
+	return w.stop(stopConfig{ctx: context.Background(), timeout: remaining})
+}
+
+// OnStopNoHooks drains connections like `OnStop`, honoring the timeout, but never
+// runs the shutdown hooks. This is a fast path for shutdowns where cleanup should be
+// skipped, such as a crash-restart.
+func (w *Watcher) OnStopNoHooks() error {
+	if w == nil {
+		return errors.New("OnStopNoHooks: receiver is nil")
+	}
+	return w.stop(stopConfig{ctx: context.Background(), skipHooks: true})
+}
+
+// OnStopTimed is `OnStop` that also returns how long the drain took, from the start
+// of the drain until connections drained, or until it timed out or was cancelled. It
+// does not include the time spent running hooks, so it can be compared directly with
+// the grace period when tuning it.
+//
+// Example use:
+//
+//	elapsed, err := watcher.OnStopTimed()
+//	log.Printf("drain took %v", elapsed)
+func (w *Watcher) OnStopTimed() (time.Duration, error) {
+	if w == nil {
+		return 0, errors.New("OnStopTimed: receiver is nil")
+	}
+	var elapsed time.Duration
+	err := w.stop(stopConfig{ctx: context.Background(), drainTime: &elapsed})
+	return elapsed, err
+}
+
+// Cancel aborts an in-progress `OnStop`, which stops waiting for connections, runs
+// the shutdown hooks and returns `ErrCancelled`. An error is returned if no drain is
+// in progress. Afterwards `Accepting(true)` resumes normal operation.
+func (w *Watcher) Cancel() error {
+	if w == nil {
+		return errors.New("Cancel: receiver is nil")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancelChan == nil {
+		return errors.New("Cancel: no shutdown in progress")
+	}
+	close(w.cancelChan)
+	w.cancelChan = nil
+	w.cancelled = true
+	return nil
+}
+
+// Reset returns the Watcher to its freshly constructed state so it can manage a new
+// server lifecycle: connection counts are zeroed and the accepting and shutting down
+// flags are cleared. Hooks and configuration are kept. An error is returned if an
+// `OnStop` is in progress, including while it runs hooks after a `Cancel`.
+func (w *Watcher) Reset() error {
+	if w == nil {
+		return errors.New("Reset: receiver is nil")
+	}
+	w.mu.Lock()
+	defer w.unlockAndNotify()
+	if w.stopping > 0 {
+		return errors.New("Reset: shutdown in progress")
+	}
+	w.activeConns.Store(0)
+	w.tlsConns = 0
+	w.peakConns.Store(0)
+	w.conns = nil
+	w.rejectedConns = 0
+	w.unbalancedCloses = 0
+	w.stopExpiryLocked()
+	w.stateViolations = 0
+	w.lastViolation = ""
+	if w.accepting {
+		w.acceptingChan = nil
+		w.acceptingChangedLocked(false)
 	}
+	w.accepting = false
+	w.rejecting = false
+	w.everAccepted = false
+	w.quiesced = false
+	if w.done {
+		w.doneChan = nil
+		w.done = false
+	}
+	w.ready = false
+	w.shuttingDown = false
+	w.cancelled = false
+	w.lastTimedOut = false
+	w.lastDrain = 0
+	w.updateFastPathLocked()
+	return nil
 }
 
 // SigHandle is an example of a typical signal handler that will attempt a graceful shutdown
@@ -126,36 +755,63 @@ func (w *Watcher) OnStop() error {
 // This should be called prior to starting your http daemon. Place it in its own goroutine
 // so signals can be recorded after the daemon has taken over control of the main thread.
 //
+// SIGTERM, SIGQUIT and SIGHUP shut the daemon down gracefully. SIGINT panics, unless
+// the Watcher was configured `WithInterruptGraceful(true)` in which case it is treated
+// like SIGTERM. See `WithTwoPhaseShutdown` to split shutdown across two signals.
+// Other signals are logged unless dropped with `WithIgnoredSignals`.
+//
 // Example use:
 //
-//         go func() {
-//                 sigs := make(chan os.Signal, 1)
-//                 exitcode := make(chan int, 1)
-//                 signal.Notify(sigs)
-//                 go watcher.SigHandle(sigs, exitcode)
-//                 code := <-exitcode
-//                 log.Printf("exit with code:%d", code)
-//                 os.Exit(code)
-// 	}()
+//	        go func() {
+//	                sigs := make(chan os.Signal, 1)
+//	                exitcode := make(chan int, 1)
+//	                signal.Notify(sigs)
+//	                go watcher.SigHandle(sigs, exitcode)
+//	                code := <-exitcode
+//	                log.Printf("exit with code:%d", code)
+//	                os.Exit(code)
+//		}()
 func (w *Watcher) SigHandle(sigs <-chan os.Signal, exitcode chan<- int) {
 	if w == nil {
 		// panic since this will typically be launched as a goroutine.
 		panic("SigHandler: Watcher is nil")
 	}
+	w.SigHandleContext(context.Background(), sigs, exitcode)
+}
+
+// SigHandleContext is `SigHandle` with a context that is passed on to `OnStopContext`
+// when a terminating signal arrives.
+func (w *Watcher) SigHandleContext(ctx context.Context, sigs <-chan os.Signal, exitcode chan<- int) {
+	if w == nil {
+		// panic since this will typically be launched as a goroutine.
+		panic("SigHandleContext: Watcher is nil")
+	}
 	for sig := range sigs {
-		if sig == syscall.SIGTERM || sig == syscall.SIGQUIT || sig == syscall.SIGHUP {
-			// The signals that terminate the daemon.
-			stopErr := w.OnStop()
-			if stopErr != nil {
-				exitcode <- 1 // caller should os.Exit(1)
-			}
-			exitcode <- 0 // caller should os.Exit(0)
-		} else if sig == syscall.SIGINT {
+		if w.signalIgnored(sig) {
+			continue
+		}
+		if sig == syscall.SIGINT && !w.interruptGraceful() {
 			// Unclean shutdown with panic message.
 			panic("panic exit")
+		} else if isTerminating(sig) || sig == syscall.SIGINT {
+			// The signals that terminate the daemon. In two-phase mode the first
+			// one only stops accepting, so load balancers can move traffic away.
+			if w.beginTwoPhaseShutdown() {
+				continue
+			}
+			stopErr := w.OnStopContext(ctx)
+			if stopErr != nil {
+				exitcode <- w.ExitCode(stopErr) // 1 unless WithFailureExitCode says otherwise
+			} else {
+				exitcode <- w.exitCode(sig) // 0 unless WithSignalExitCodes says otherwise
+			}
+		} else if f := w.sigHandler(sig); f != nil {
+			// A user action that leaves the daemon running. There is no one to
+			// report the error to here, so the handler must deal with it.
+			_ = f()
 		} else {
-			// uncomment this if you want to see uncaught signals
-			// log.Printf("**** caught unchecked signal %v\n", sig)
+			// Silence signals like these with WithIgnoredSignals.
+			w.logf("SigHandleContext: caught unchecked signal %v", sig)
 		}
 	}
 }
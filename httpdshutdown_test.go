@@ -1,6 +1,8 @@
 package httpdshutdown
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -25,12 +27,113 @@ func TestNil(t *testing.T) {
 }
 
 func TestBadTimeout(t *testing.T) {
-	_, wErr := NewWatcher(-1)
+	_, wErr := NewWatcher(-2)
 	if wErr == nil {
 		t.Errorf("TestBadTimeout: should have error")
 	}
 }
 
+func TestZeroTimeout(t *testing.T) {
+	w, wErr := NewWatcher(0)
+	if w != nil || wErr == nil {
+		t.Errorf("TestZeroTimeout: should have error")
+	}
+}
+
+func TestNewWatcherDuration(t *testing.T) {
+	wMS, errMS := NewWatcher(2000)
+	wDur, errDur := NewWatcherDuration(2 * time.Second)
+	if wMS == nil || errMS != nil || wDur == nil || errDur != nil {
+		t.Fatalf("TestNewWatcherDuration: should not be nil")
+	}
+	if wMS.timeout != wDur.timeout || wDur.timeout != 2*time.Second {
+		t.Errorf("TestNewWatcherDuration: should have equal timeouts, got %v and %v", wMS.timeout, wDur.timeout)
+	}
+	if getStatus(t, wDur).TimeoutMS != 2000 {
+		t.Errorf("TestNewWatcherDuration: status should report the timeout in ms")
+	}
+	for _, w := range []*Watcher{wMS, wDur} {
+		clock := newFakeClock()
+		_ = w.Configure(WithClock(clock))
+		w.RecordConn(true)
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- w.OnStop()
+		}()
+		clock.BlockUntil(1)
+		clock.Advance(2*time.Second - time.Millisecond)
+		select {
+		case <-errChan:
+			t.Errorf("TestNewWatcherDuration: should not time out before 2s")
+		case <-time.After(10 * time.Millisecond):
+		}
+		clock.Advance(time.Millisecond)
+		if <-errChan == nil {
+			t.Errorf("TestNewWatcherDuration: should time out after 2s")
+		}
+	}
+
+	w, wErr := NewWatcherDuration(NoTimeout)
+	if w == nil || wErr != nil || getStatus(t, w).TimeoutMS != NoTimeout {
+		t.Errorf("TestNewWatcherDuration: should accept NoTimeout")
+	}
+	if _, wErr = NewWatcherDuration(0); wErr == nil {
+		t.Errorf("TestNewWatcherDuration: should reject a zero timeout")
+	}
+}
+
+func TestNilHook(t *testing.T) {
+	w, wErr := NewWatcher(2000, sampleShutdownHook, nil)
+	if w != nil || wErr == nil {
+		t.Errorf("TestNilHook: should have error")
+	}
+	w, wErr = NewWatcher(2000, sampleShutdownHook)
+	if w == nil || wErr != nil {
+		t.Errorf("TestNilHook: should not have error")
+	}
+}
+
+func TestNoTimeout(t *testing.T) {
+	w, wErr := NewWatcher(NoTimeout, sampleShutdownHook)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestNoTimeout: should not be nil")
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(1500 * time.Millisecond)
+		fmt.Fprintln(w, "Hello, client")
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(handler))
+	ts.Config.ConnState = func(conn net.Conn, newState http.ConnState) {
+		w.RecordConnState(newState)
+	}
+	ts.Config.SetKeepAlivesEnabled(false)
+	ts.Start()
+	defer ts.Close()
+
+	go func() {
+		getResp, getErr := http.Get(ts.URL)
+		if getErr != nil {
+			t.Error(getErr)
+			return
+		}
+		getResp.Body.Close()
+	}()
+	for w.ActiveConns() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	start := time.Now()
+	err := w.OnStop()
+	if err != nil {
+		t.Errorf("TestNoTimeout: should not have an error")
+	}
+	if time.Since(start) < time.Second {
+		t.Errorf("TestNoTimeout: OnStop should have waited for the slow handler")
+	}
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestNoTimeout: conn should be closed")
+	}
+}
+
 func TestValid(t *testing.T) {
 	w, wErr := NewWatcher(3000)
 	if w == nil || wErr != nil {
@@ -48,12 +151,15 @@ func sampleShutdownHook() error {
 }
 
 func TestStop(t *testing.T) {
-	w, wErr := NewWatcher(3000, sampleShutdownHook)
-	if w == nil || wErr != nil {
-		t.Errorf("TestStop: should not be nil")
-	}
+	w, clock := newFakeClockWatcher(t, 3000, sampleShutdownHook)
 	w.RecordConnState(http.StateNew)
-	err := w.OnStop()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(3 * time.Second)
+	err := <-errChan
 	if err == nil {
 		t.Errorf("TestStop: should have error from 1 second timeout to force stop")
 	}
@@ -72,124 +178,688 @@ func TestStop(t *testing.T) {
 
 func TestHttpDaemonTimeout(t *testing.T) {
 	fmt.Printf("\n\n")
-	w, wErr := NewWatcher(2000, sampleShutdownHook)
-	if w == nil || wErr != nil {
-		t.Errorf("TestHttpDaemonTimeout: should not be nil")
-	}
+	w, clock := newFakeClockWatcher(t, 2000, sampleShutdownHook)
 
+	started, release := make(chan struct{}), make(chan struct{})
 	handler := func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("hello from the test daemon; sleeping")
-		time.Sleep(5 * time.Second)
+		fmt.Println("hello from the test daemon; blocking")
+		close(started)
+		<-release
 		fmt.Println("goodbye from the test daemon")
 		fmt.Fprintln(w, "Hello, client")
-		return
 	}
 
 	ts := httptest.NewUnstartedServer(http.HandlerFunc(handler))
-
 	ts.Config.ConnState = func(conn net.Conn, newState http.ConnState) {
 		fmt.Printf("(0) NEW CONN STATE:%v\n", newState)
 		w.RecordConnState(newState)
-		return
 	}
-	// needed to force the connection to close
-	ts.Config.ReadTimeout = 8 * time.Second
-	ts.Config.WriteTimeout = 8 * time.Second
-
 	ts.Start()
 	defer ts.Close()
 
-	var wg sync.WaitGroup
-
 	fmt.Println("watcher should trigger before handler completes: should see a timeout message")
 
-	wg.Add(1)
+	respErr := make(chan error, 1)
 	go func() {
-		fmt.Println("about to call handler")
 		getResp, getErr := http.Get(ts.URL)
 		if getErr != nil {
-			t.Errorf(getErr.Error())
+			respErr <- getErr
+			return
 		}
 		_, readErr := ioutil.ReadAll(getResp.Body)
 		getResp.Body.Close()
-		if readErr != nil {
-			t.Errorf(readErr.Error())
-		}
-		wg.Done()
+		respErr <- readErr
 	}()
+	<-started
 
-	wg.Add(1)
+	stopErr := make(chan error, 1)
 	go func() {
-		fmt.Println("about to call OnStop, sleep 1 first")
-		time.Sleep(1 * time.Second)
-		err := w.OnStop()
-		if err == nil {
-			t.Errorf("TestHttpDaemonTimeout: should have an error, a timeout was supposed to occur")
-		}
-		wg.Done()
+		stopErr <- w.OnStop()
 	}()
-
-	wg.Wait()
+	clock.BlockUntil(1)
+	clock.Advance(2 * time.Second)
+	if err := <-stopErr; err == nil {
+		t.Errorf("TestHttpDaemonTimeout: should have an error, a timeout was supposed to occur")
+	}
+	close(release)
+	if err := <-respErr; err != nil {
+		t.Error(err)
+	}
 }
 
 func TestHttpDaemonNormalExit(t *testing.T) {
 	fmt.Printf("\n\n")
-	w, wErr := NewWatcher(20000, sampleShutdownHook)
-	if w == nil || wErr != nil {
-		t.Errorf("TestHttpDaemonNormalExit: should not be nil")
-	}
+	w, clock := newFakeClockWatcher(t, 20000, sampleShutdownHook)
 
+	started, release := make(chan struct{}), make(chan struct{})
 	handler := func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("hello from the test daemon; sleeping")
-		time.Sleep(1 * time.Second)
+		fmt.Println("hello from the test daemon; blocking")
+		close(started)
+		<-release
 		fmt.Println("goodbye from the test daemon")
 		fmt.Fprintln(w, "Hello, client")
-		return
 	}
 
 	ts := httptest.NewUnstartedServer(http.HandlerFunc(handler))
-
 	ts.Config.ConnState = func(conn net.Conn, newState http.ConnState) {
 		fmt.Printf("(1) NEW CONN STATE:%v\n", newState)
 		w.RecordConnState(newState)
-		return
 	}
-	// needed to force the connection to close
-	ts.Config.ReadTimeout = 3 * time.Second
-	ts.Config.WriteTimeout = 3 * time.Second
-
 	ts.Start()
 	defer ts.Close()
 
-	var wg sync.WaitGroup
-
 	fmt.Println("watcher should not trigger before handler completes: should see no timeout message")
 
-	wg.Add(1)
+	respErr := make(chan error, 1)
 	go func() {
-		fmt.Println("about to call handler")
-		getResp, getErr := http.Get(ts.URL)
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Close = true // so the conn closes once the response is read
+		getResp, getErr := http.DefaultClient.Do(req)
 		if getErr != nil {
-			t.Errorf(getErr.Error())
+			respErr <- getErr
+			return
 		}
 		_, readErr := ioutil.ReadAll(getResp.Body)
 		getResp.Body.Close()
-		if readErr != nil {
-			t.Errorf(readErr.Error())
-		}
-		wg.Done()
+		respErr <- readErr
+	}()
+	<-started
+
+	stopErr := make(chan error, 1)
+	go func() {
+		stopErr <- w.OnStop()
 	}()
+	clock.BlockUntil(1)
+	close(release)
+	if err := <-respErr; err != nil {
+		t.Error(err)
+	}
+	if err := <-stopErr; err != nil {
+		t.Errorf("TestHttpDaemonNormalExit: should have no error, no timeout was supposed to occur")
+	}
+}
 
-	wg.Add(1)
+func TestRecordConn(t *testing.T) {
+	w, wErr := NewWatcher(3000, sampleShutdownHook)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestRecordConn: should not be nil")
+	}
+	w.RecordConn(true)
+	w.RecordConn(true)
+	if w.ActiveConns() != 2 {
+		t.Errorf("TestRecordConn: should have 2 active conns")
+	}
 	go func() {
-		fmt.Println("about to call OnStop, sleep 1 first")
-		time.Sleep(1 * time.Second)
-		err := w.OnStop()
-		if err != nil {
-			t.Errorf("TestHttpDaemonNormalExit: should have no error, no timeout was supposed to occur")
+		time.Sleep(100 * time.Millisecond)
+		w.RecordConn(false)
+		w.RecordConn(false)
+	}()
+	start := time.Now()
+	err := w.OnStop()
+	if err != nil {
+		t.Errorf("TestRecordConn: should not have an error")
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Errorf("TestRecordConn: OnStop should have waited for conns to close")
+	}
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestRecordConn: should have 0 active conns")
+	}
+}
+
+func TestConnOpenedClosed(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestConnOpenedClosed: should not be nil")
+	}
+	w.ConnClosed()
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestConnOpenedClosed: count should stay at zero, got %d", w.ActiveConns())
+	}
+	w.Accepting(true)
+	w.ConnOpened()
+	w.ConnOpened()
+	w.ConnClosed()
+	if w.ActiveConns() != 1 {
+		t.Errorf("TestConnOpenedClosed: should have 1 active conn, got %d", w.ActiveConns())
+	}
+	w.Accepting(false)
+	w.ConnOpened()
+	if w.ActiveConns() != 2 || w.RejectedConns() != 1 {
+		t.Errorf("TestConnOpenedClosed: rejected conn should still be counted, got %d active", w.ActiveConns())
+	}
+	w.ConnClosed() // the rejected conn
+	w.ConnClosed()
+	w.ConnClosed()
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestConnOpenedClosed: count should stay at zero, got %d", w.ActiveConns())
+	}
+}
+
+func TestTryAccept(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestTryAccept: should not be nil")
+	}
+	err := w.Configure(WithMaxConns(2))
+	if err != nil {
+		t.Fatalf("TestTryAccept: should not have error")
+	}
+	if !w.TryAccept() || !w.TryAccept() {
+		t.Errorf("TestTryAccept: should accept below the cap")
+	}
+	if w.TryAccept() {
+		t.Errorf("TestTryAccept: should reject at the cap")
+	}
+	if w.ActiveConns() != 2 {
+		t.Errorf("TestTryAccept: rejected conn should not be counted")
+	}
+	w.RecordConn(false)
+	if !w.TryAccept() {
+		t.Errorf("TestTryAccept: should accept after a conn closes")
+	}
+	w.RecordConn(false)
+	w.RecordConn(false)
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestTryAccept: should not have an error")
+	}
+	err = w.Configure(WithMaxConns(-1))
+	if err == nil {
+		t.Errorf("TestTryAccept: should have error for negative cap")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	w, wErr := NewWatcher(NoTimeout)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestCancel: should not be nil")
+	}
+	err := w.Cancel()
+	if err == nil {
+		t.Errorf("TestCancel: should have error with no shutdown in progress")
+	}
+	hookRan := false
+	_ = w.AddContextHook(func(ctx context.Context) error {
+		hookRan = true
+		return nil
+	})
+	w.RecordConn(true) // never closed
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancelErr := w.Cancel()
+		if cancelErr != nil {
+			t.Errorf("TestCancel: should not have error cancelling: %v", cancelErr)
 		}
-		wg.Done()
 	}()
+	err = w.OnStop()
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("TestCancel: should have ErrCancelled, got %v", err)
+	}
+	if !hookRan {
+		t.Errorf("TestCancel: hooks should run after cancel")
+	}
+}
+
+func TestResumeAfterCancel(t *testing.T) {
+	w, wErr := NewWatcher(NoTimeout)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestResumeAfterCancel: should not be nil")
+	}
+	w.Accepting(true)
+	w.RecordConnState(http.StateNew) // never closed
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = w.Cancel()
+	}()
+	err := w.OnStop()
+	if !errors.Is(err, ErrCancelled) {
+		t.Fatalf("TestResumeAfterCancel: should have ErrCancelled, got %v", err)
+	}
+	w.RecordConnState(http.StateNew)
+	if w.ActiveConns() != 2 || w.RejectedConns() != 1 {
+		t.Errorf("TestResumeAfterCancel: should reject conns until resumed")
+	}
+	w.Accepting(true)
+	if w.IsShuttingDown() || !w.IsAccepting() {
+		t.Errorf("TestResumeAfterCancel: should resume normal operation")
+	}
+	select {
+	case <-w.Done():
+		t.Errorf("TestResumeAfterCancel: Done should wait for the next shutdown")
+	default:
+	}
+	w.RecordConnState(http.StateNew)
+	if w.ActiveConns() != 3 || w.RejectedConns() != 1 {
+		t.Errorf("TestResumeAfterCancel: new conns should be counted again, got %d", w.ActiveConns())
+	}
+	if err := w.Validate(); err != nil {
+		t.Errorf("TestResumeAfterCancel: should be valid: %v", err)
+	}
+}
 
+func TestNoResumeAfterTimeout(t *testing.T) {
+	w, wErr := NewWatcher(50)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestNoResumeAfterTimeout: should not be nil")
+	}
+	w.RecordConn(true) // never closed
+	_ = w.OnStop()
+	w.Accepting(true)
+	if !w.IsShuttingDown() {
+		t.Errorf("TestNoResumeAfterTimeout: only a cancelled shutdown should be resumable")
+	}
+}
+
+func TestConcurrentRecordConnState(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestConcurrentRecordConnState: should not be nil")
+	}
+	w.Accepting(true)
+	w.RecordConnState(http.StateNew) // keeps OnStop waiting until the end
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				w.RecordConnState(http.StateNew)
+				w.RecordConnState(http.StateActive)
+				_ = w.IsAccepting()
+				_ = w.ActiveConns()
+				w.RecordConnState(http.StateClosed)
+			}
+		}()
+	}
 	wg.Wait()
+	w.RecordConnState(http.StateClosed)
+	err := <-errChan
+	if err != nil {
+		t.Errorf("TestConcurrentRecordConnState: should not have error: %v", err)
+	}
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestConcurrentRecordConnState: should have 0 active conns")
+	}
+}
+
+func TestResetAfterCancel(t *testing.T) {
+	preStarted, preRelease := make(chan struct{}), make(chan struct{})
+	hookStarted, hookRelease := make(chan struct{}), make(chan struct{})
+	w, clock := newFakeClockWatcher(t, 3000, func() error {
+		close(hookStarted)
+		<-hookRelease
+		return nil
+	})
+	_ = w.AddPreDrainHook(func() error {
+		close(preStarted)
+		<-preRelease
+		return nil
+	})
+	w.Accepting(true)
+	w.RecordConn(true) // never closed, so the drain only ends when cancelled
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	<-preStarted
+	if w.Reset() == nil {
+		t.Errorf("TestResetAfterCancel: should not reset while pre-drain hooks run")
+	}
+	close(preRelease)
+	clock.BlockUntil(1)
+	if err := w.Cancel(); err != nil {
+		t.Fatalf("TestResetAfterCancel: should cancel, got %v", err)
+	}
+	<-hookStarted
+	if w.Reset() == nil {
+		t.Errorf("TestResetAfterCancel: should not reset while hooks run after Cancel")
+	}
+	close(hookRelease)
+	if !errors.Is(<-errChan, ErrCancelled) {
+		t.Errorf("TestResetAfterCancel: drain should be cancelled")
+	}
+	if err := w.Reset(); err != nil {
+		t.Fatalf("TestResetAfterCancel: should reset once OnStop returns, got %v", err)
+	}
+	if w.IsShuttingDown() || w.CurrentPhase() != PhaseInitializing {
+		t.Errorf("TestResetAfterCancel: reset should not be undone, got phase %v", w.CurrentPhase())
+	}
+}
+
+func TestReset(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000, sampleShutdownHook)
+	w.Accepting(true)
+	w.RecordConn(true) // never closed, so the first drain times out
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	err := w.Reset()
+	if err == nil {
+		t.Errorf("TestReset: should not reset during a drain")
+	}
+	clock.Advance(3 * time.Second)
+	err = <-errChan
+	if err == nil {
+		t.Errorf("TestReset: first drain should time out")
+	}
+
+	err = w.Reset()
+	if err != nil {
+		t.Fatalf("TestReset: should not have error: %v", err)
+	}
+	if w.ActiveConns() != 0 || w.IsAccepting() || w.IsShuttingDown() {
+		t.Errorf("TestReset: state should be cleared")
+	}
+	w.Accepting(true)
+	w.RecordConn(true)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		w.RecordConn(false)
+	}()
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestReset: second drain should not have error: %v", err)
+	}
+	if len(w.LastHookResults()) != 1 {
+		t.Errorf("TestReset: hooks should be preserved")
+	}
+}
+
+func TestOnStopNoHooks(t *testing.T) {
+	hookRan := false
+	w, wErr := NewWatcher(3000, func() error {
+		hookRan = true
+		return nil
+	})
+	if w == nil || wErr != nil {
+		t.Fatalf("TestOnStopNoHooks: should not be nil")
+	}
+	w.RecordConn(true)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		w.RecordConn(false)
+	}()
+	err := w.OnStopNoHooks()
+	if err != nil {
+		t.Errorf("TestOnStopNoHooks: should not have error: %v", err)
+	}
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestOnStopNoHooks: should have drained")
+	}
+	if hookRan {
+		t.Errorf("TestOnStopNoHooks: hook should not run")
+	}
+}
+
+func TestOnStopWithTimeout(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000)
+	w.RecordConn(true) // never closed
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStopWithTimeout(10 * time.Second)
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(3 * time.Second)
+	select {
+	case <-errChan:
+		t.Fatalf("TestOnStopWithTimeout: should not use the Watcher's timeout")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(7 * time.Second)
+	err := <-errChan
+	if err == nil {
+		t.Errorf("TestOnStopWithTimeout: should time out after the per-call timeout")
+	}
+	if w.status().TimeoutMS != 3000 {
+		t.Errorf("TestOnStopWithTimeout: Watcher's timeout should be unchanged")
+	}
+	if w.OnStopWithTimeout(0) == nil {
+		t.Errorf("TestOnStopWithTimeout: should reject a zero timeout")
+	}
+}
+
+func TestOnStopDeadline(t *testing.T) {
+	w, wErr := NewWatcher(10000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestOnStopDeadline: should not be nil")
+	}
+	w.RecordConn(true) // never closed
+	start := time.Now()
+	err := w.OnStopDeadline(start.Add(300 * time.Millisecond))
+	elapsed := time.Since(start)
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) || !shutdownErr.TimedOut {
+		t.Errorf("TestOnStopDeadline: should time out at the deadline, got %v", err)
+	}
+	if elapsed < 250*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("TestOnStopDeadline: should time out at about the deadline, took %v", elapsed)
+	}
+
+	w, _ = NewWatcher(10000)
+	w.RecordConn(true)
+	start = time.Now()
+	if w.OnStopDeadline(start.Add(-time.Second)) == nil {
+		t.Errorf("TestOnStopDeadline: should time out for a past deadline")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("TestOnStopDeadline: should time out at once for a past deadline, took %v", elapsed)
+	}
+}
+
+func TestOnStopTimed(t *testing.T) {
+	w, wErr := NewWatcher(3000, func() error {
+		// Hook time is not part of the drain.
+		time.Sleep(time.Second)
+		return nil
+	})
+	if w == nil || wErr != nil {
+		t.Fatalf("TestOnStopTimed: should not be nil")
+	}
+	started := make(chan struct{})
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+	}))
+	ts.Config.ConnState = func(conn net.Conn, newState http.ConnState) {
+		w.RecordConnState(newState)
+	}
+	ts.Start()
+	defer ts.Close()
+	_ = w.ManageServer(ts.Config)
+
+	go func() {
+		resp, err := http.Get(ts.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	elapsed, err := w.OnStopTimed()
+	if err != nil {
+		t.Errorf("TestOnStopTimed: should not have error: %v", err)
+	}
+	if elapsed < 150*time.Millisecond || elapsed > 900*time.Millisecond {
+		t.Errorf("TestOnStopTimed: drain should take about 200ms, got %v", elapsed)
+	}
+}
+
+func TestPeakConns(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestPeakConns: should not be nil")
+	}
+	for _, n := range []int{3, 7, 2} {
+		for i := 0; i < n; i++ {
+			w.RecordConn(true)
+		}
+		for i := 0; i < n; i++ {
+			w.RecordConn(false)
+		}
+	}
+	if w.PeakConns() != 7 {
+		t.Errorf("TestPeakConns: peak should be 7, got %d", w.PeakConns())
+	}
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestPeakConns: should have 0 active conns")
+	}
+	_ = w.TryAccept()
+	if w.PeakConns() != 7 {
+		t.Errorf("TestPeakConns: peak should not decrease, got %d", w.PeakConns())
+	}
+	w.RecordConn(false)
+	err := w.Reset()
+	if err != nil || w.PeakConns() != 0 {
+		t.Errorf("TestPeakConns: Reset should clear the peak")
+	}
+}
+
+func TestAcceptingDuringDrain(t *testing.T) {
+	w, wErr := NewWatcher(NoTimeout)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestAcceptingDuringDrain: should not be nil")
+	}
+	w.Accepting(true)
+	w.RecordConn(true)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	for !w.IsShuttingDown() {
+		time.Sleep(time.Millisecond)
+	}
+	w.Accepting(true)
+	if w.IsAccepting() {
+		t.Errorf("TestAcceptingDuringDrain: Accepting(true) should be ignored during a drain")
+	}
+	if err := w.Validate(); err != nil {
+		t.Errorf("TestAcceptingDuringDrain: should be valid, got %v", err)
+	}
+	w.RecordConn(false)
+	if err := <-errChan; err != nil {
+		t.Errorf("TestAcceptingDuringDrain: should not have error: %v", err)
+	}
+}
+
+func TestRejectedConns(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestRejectedConns: should not be nil")
+	}
+	w.Accepting(true)
+	w.RecordConnState(http.StateNew)
+	w.Accepting(false)
+	for i := 0; i < 3; i++ {
+		w.RecordConnState(http.StateNew)
+	}
+	if w.RejectedConns() != 3 || w.ActiveConns() != 4 {
+		t.Errorf("TestRejectedConns: should reject 3 and count 4, got %d and %d",
+			w.RejectedConns(), w.ActiveConns())
+	}
+	if w.TryAccept() {
+		t.Errorf("TestRejectedConns: TryAccept should refuse while not accepting")
+	}
+	if w.RejectedConns() != 4 {
+		t.Errorf("TestRejectedConns: should count the refused TryAccept")
+	}
+	for i := 0; i < 4; i++ {
+		w.RecordConnState(http.StateClosed)
+	}
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestRejectedConns: should have 0 active conns, got %d", w.ActiveConns())
+	}
+	err := w.OnStop()
+	if err != nil {
+		t.Errorf("TestRejectedConns: should not have error: %v", err)
+	}
+}
+
+func TestDeadlineExceeded(t *testing.T) {
+	release := make(chan struct{})
+	w, clock := newFakeClockWatcher(t, 1000, func() error {
+		<-release // a stalled hook
+		return nil
+	})
+	if w.DeadlineExceeded() {
+		t.Errorf("TestDeadlineExceeded: should be false before OnStop")
+	}
+	w.RecordConn(true) // never closed
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(500 * time.Millisecond)
+	if w.DeadlineExceeded() {
+		t.Errorf("TestDeadlineExceeded: should be false within the grace period")
+	}
+	clock.Advance(600 * time.Millisecond)
+	if !w.DeadlineExceeded() {
+		t.Errorf("TestDeadlineExceeded: should be true past the grace period")
+	}
+	close(release)
+	if <-errChan == nil {
+		t.Errorf("TestDeadlineExceeded: should have timed out")
+	}
+	if w.DeadlineExceeded() {
+		t.Errorf("TestDeadlineExceeded: should be false once OnStop returns")
+	}
+}
+
+func TestLastShutdownTimedOut(t *testing.T) {
+	w, wErr := NewWatcher(50)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestLastShutdownTimedOut: should not be nil")
+	}
+	_ = w.OnStop()
+	if w.LastShutdownTimedOut() {
+		t.Errorf("TestLastShutdownTimedOut: should be false after a graceful drain")
+	}
+	_ = w.Reset()
+	w.RecordConn(true) // never closed
+	_ = w.OnStop()
+	if !w.LastShutdownTimedOut() {
+		t.Errorf("TestLastShutdownTimedOut: should be true after a timed out drain")
+	}
+	_ = w.Reset()
+	if w.LastShutdownTimedOut() {
+		t.Errorf("TestLastShutdownTimedOut: should be cleared by Reset")
+	}
+}
+
+func TestOnAcceptingChanged(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestOnAcceptingChanged: should not be nil")
+	}
+	var changes []bool
+	err := w.OnAcceptingChanged(func(accepting bool) {
+		// Called without the lock held, so the Watcher can be queried.
+		if w.IsAccepting() != accepting {
+			t.Errorf("TestOnAcceptingChanged: should see the new value")
+		}
+		changes = append(changes, accepting)
+	})
+	if err != nil {
+		t.Fatalf("TestOnAcceptingChanged: should not have error")
+	}
+	if w.OnAcceptingChanged(nil) == nil {
+		t.Errorf("TestOnAcceptingChanged: should reject a nil callback")
+	}
+	w.Accepting(true)
+	w.Accepting(true) // not a flip
+	w.Accepting(false)
+	w.Accepting(true)
+	_ = w.OnStop()
+	want := []bool{true, false, true, false}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Errorf("TestOnAcceptingChanged: should see %v, got %v", want, changes)
+	}
 }
@@ -0,0 +1,31 @@
+// Package httpdshutdowntest provides helpers for testing code that uses an
+// httpdshutdown `Watcher`, without standing up a real http daemon.
+package httpdshutdowntest
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bradclawsie/httpdshutdown"
+)
+
+// SimulateConnection records the connection states a server reports for a single
+// request on w: new, then active for handlerDuration while the request is handled,
+// then idle and closed. It returns once the connection is closed, so it is
+// typically launched as a goroutine alongside a call to `OnStop`.
+//
+// Example use:
+//
+//	go httpdshutdowntest.SimulateConnection(watcher, 100*time.Millisecond)
+//	err := watcher.OnStop()
+func SimulateConnection(w *httpdshutdown.Watcher, handlerDuration time.Duration) {
+	if w == nil {
+		// panic since this will typically be launched as a goroutine.
+		panic("SimulateConnection: Watcher is nil")
+	}
+	w.RecordConnState(http.StateNew)
+	w.RecordConnState(http.StateActive)
+	time.Sleep(handlerDuration)
+	w.RecordConnState(http.StateIdle)
+	w.RecordConnState(http.StateClosed)
+}
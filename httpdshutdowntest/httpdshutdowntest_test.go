@@ -0,0 +1,62 @@
+package httpdshutdowntest
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradclawsie/httpdshutdown"
+)
+
+func TestSimulateConnection(t *testing.T) {
+	w, wErr := httpdshutdown.NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestSimulateConnection: should not be nil")
+	}
+	buf := new(bytes.Buffer)
+	err := w.Configure(httpdshutdown.WithLogger(log.New(buf, "", 0)), httpdshutdown.WithConnStateLogging(true))
+	if err != nil {
+		t.Fatalf("TestSimulateConnection: should not have error")
+	}
+	SimulateConnection(w, time.Millisecond)
+	want := []string{
+		"RecordConnState: state=new active=1",
+		"RecordConnState: state=active active=1",
+		"RecordConnState: state=idle active=1",
+		"RecordConnState: state=closed active=0",
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("TestSimulateConnection: should record %d states, got %q", len(want), buf.String())
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("TestSimulateConnection: state %d should be %q, got %q", i, want[i], lines[i])
+		}
+	}
+}
+
+func TestSimulateConnectionDrain(t *testing.T) {
+	w, wErr := httpdshutdown.NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestSimulateConnectionDrain: should not be nil")
+	}
+	done := make(chan struct{})
+	go func() {
+		SimulateConnection(w, 100*time.Millisecond)
+		close(done)
+	}()
+	for w.ActiveConns() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	err := w.OnStop()
+	if err != nil {
+		t.Errorf("TestSimulateConnectionDrain: should not have error: %v", err)
+	}
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestSimulateConnectionDrain: OnStop should wait for the simulated conn, got %d open", w.ActiveConns())
+	}
+	<-done
+}
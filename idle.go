@@ -0,0 +1,41 @@
+package httpdshutdown
+
+import (
+	"net"
+	"net/http"
+)
+
+// WithCloseIdleOnShutdown makes `OnStop` close idle keep-alive connections as soon as
+// it begins, and any connection that goes idle during the drain, so the drain only
+// waits on connections with a request in progress. Managed servers have keep-alives
+// disabled, and connections recorded with `RecordConnStateConn` are closed directly,
+// which also covers servers that are not managed. By default idle connections are
+// left to close on their own or to be closed by `http.Server.Shutdown`.
+func WithCloseIdleOnShutdown(closeIdle bool) Option {
+	return func(w *Watcher) error {
+		w.closeIdle = closeIdle
+		return nil
+	}
+}
+
+// closeIdleConns disables keep-alives on the managed servers and closes the tracked
+// connections that are idle.
+func (w *Watcher) closeIdleConns() {
+	w.mu.Lock()
+	servers := make([]*http.Server, len(w.servers))
+	copy(servers, w.servers)
+	var idle []net.Conn
+	for c, info := range w.conns {
+		if info.idle {
+			idle = append(idle, c)
+		}
+	}
+	w.mu.Unlock()
+	for _, srv := range servers {
+		// This also closes the server's idle connections.
+		srv.SetKeepAlivesEnabled(false)
+	}
+	for _, c := range idle {
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,54 @@
+package httpdshutdown
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCloseIdleOnShutdown(t *testing.T) {
+	w, wErr := NewWatcher(5000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestCloseIdleOnShutdown: should not be nil")
+	}
+	err := w.Configure(WithCloseIdleOnShutdown(true))
+	if err != nil {
+		t.Fatalf("TestCloseIdleOnShutdown: should not have error")
+	}
+	w.Accepting(true)
+	idle := make(chan struct{}, 1)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	ts.Config.ConnState = func(c net.Conn, newState http.ConnState) {
+		w.RecordConnStateConn(c, newState)
+		if newState == http.StateIdle {
+			idle <- struct{}{}
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	// The client keeps the connection alive after the request.
+	client := ts.Client()
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("TestCloseIdleOnShutdown: %v", err)
+	}
+	resp.Body.Close()
+	<-idle
+	if w.ActiveConns() != 1 {
+		t.Fatalf("TestCloseIdleOnShutdown: should have an idle conn, got %d", w.ActiveConns())
+	}
+	start := time.Now()
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestCloseIdleOnShutdown: should not have error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("TestCloseIdleOnShutdown: idle conn should be closed promptly, took %v", elapsed)
+	}
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestCloseIdleOnShutdown: should have no conns, got %d", w.ActiveConns())
+	}
+}
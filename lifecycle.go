@@ -0,0 +1,66 @@
+package httpdshutdown
+
+// Phase is a stage in a Watcher's lifecycle, as reported by `CurrentPhase`. It is
+// unrelated to the hook phases of `AddHookToPhase`.
+type Phase int
+
+const (
+	PhaseInitializing Phase = iota // Not yet accepting connections.
+	PhaseAccepting                 // Accepting connections.
+	PhasePreDrain                  // OnStop is running pre-drain hooks.
+	PhaseDraining                  // Shutting down and waiting for conns, requests, workers and servers.
+	PhaseQuiesced                  // The drain has ended and hooks are running.
+	PhaseStopped                   // OnStop has returned.
+)
+
+var phaseNames = [...]string{
+	PhaseInitializing: "initializing",
+	PhaseAccepting:    "accepting",
+	PhasePreDrain:     "pre-drain",
+	PhaseDraining:     "draining",
+	PhaseQuiesced:     "quiesced",
+	PhaseStopped:      "stopped",
+}
+
+func (p Phase) String() string {
+	if p < 0 || int(p) >= len(phaseNames) {
+		return "unknown"
+	}
+	return phaseNames[p]
+}
+
+// CurrentPhase returns the stage the Watcher has reached in its lifecycle. The
+// first signal of `WithTwoPhaseShutdown` moves it to `PhaseDraining`, and `Reset`
+// returns it to `PhaseInitializing`.
+//
+// Example use:
+//
+//	log.Printf("shutdown phase: %v", watcher.CurrentPhase())
+func (w *Watcher) CurrentPhase() Phase {
+	if w == nil {
+		return PhaseInitializing
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.currentPhaseLocked()
+}
+
+// currentPhaseLocked implements `CurrentPhase`. w.mu must be held.
+func (w *Watcher) currentPhaseLocked() Phase {
+	switch {
+	case w.preDraining:
+		return PhasePreDrain
+	case w.done:
+		return PhaseStopped
+	case w.shuttingDown && w.draining:
+		return PhaseDraining
+	case w.shuttingDown && w.stopDone != nil:
+		return PhaseQuiesced
+	case w.shuttingDown:
+		// Between the two signals of a two-phase shutdown.
+		return PhaseDraining
+	case w.accepting:
+		return PhaseAccepting
+	}
+	return PhaseInitializing
+}
@@ -0,0 +1,65 @@
+package httpdshutdown
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCurrentPhase(t *testing.T) {
+	phases := make(chan Phase, 2)
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestCurrentPhase: should not be nil")
+	}
+	_ = w.AddNamedHook("cleanup", func() error {
+		phases <- w.CurrentPhase()
+		return nil
+	})
+	_ = w.AddPreDrainHook(func() error {
+		phases <- w.CurrentPhase()
+		return nil
+	})
+	if got := w.CurrentPhase(); got != PhaseInitializing {
+		t.Errorf("TestCurrentPhase: should start initializing, got %v", got)
+	}
+	w.Accepting(true)
+	if got := w.CurrentPhase(); got != PhaseAccepting {
+		t.Errorf("TestCurrentPhase: should be accepting, got %v", got)
+	}
+	w.RecordConn(true)
+	w.WorkerStarted()
+	stopErr := make(chan error, 1)
+	go func() {
+		stopErr <- w.OnStop()
+	}()
+	if got := <-phases; got != PhasePreDrain {
+		t.Errorf("TestCurrentPhase: pre-drain hook should see pre-drain, got %v", got)
+	}
+	for !w.IsShuttingDown() {
+		time.Sleep(time.Millisecond)
+	}
+	if got := w.CurrentPhase(); got != PhaseDraining {
+		t.Errorf("TestCurrentPhase: should be draining, got %v", got)
+	}
+	w.RecordConn(false)
+	if got := w.CurrentPhase(); got != PhaseDraining {
+		t.Errorf("TestCurrentPhase: should be draining while a worker runs, got %v", got)
+	}
+	w.WorkerFinished()
+	if got := <-phases; got != PhaseQuiesced {
+		t.Errorf("TestCurrentPhase: hook should see quiesced, got %v", got)
+	}
+	if err := <-stopErr; err != nil {
+		t.Errorf("TestCurrentPhase: should not have error: %v", err)
+	}
+	if got := w.CurrentPhase(); got != PhaseStopped {
+		t.Errorf("TestCurrentPhase: should be stopped, got %v", got)
+	}
+	_ = w.Reset()
+	if got := w.CurrentPhase(); got != PhaseInitializing {
+		t.Errorf("TestCurrentPhase: Reset should return to initializing, got %v", got)
+	}
+	if PhaseDraining.String() != "draining" || Phase(42).String() != "unknown" {
+		t.Errorf("TestCurrentPhase: should name the phases")
+	}
+}
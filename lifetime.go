@@ -0,0 +1,89 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// WithMaxConnLifetime closes connections once they have been open for d, whether or
+// not the Watcher is shutting down, so that no client holds a connection, and any
+// state tied to it, indefinitely. A connection is only closed while it has no
+// request in progress: one that is idle or has not sent a request yet is closed as
+// soon as it expires, and one with a request in progress once that request is done.
+// Only connections recorded with `RecordConnStateConn`, as on servers built by
+// `NewManagedServer`, are closed; hijacked connections belong to their handler and
+// are left alone.
+//
+// Connections are checked from the first one recorded, or from `Accepting(true)`,
+// until `OnStop` or `Reset` finishes.
+func WithMaxConnLifetime(d time.Duration) Option {
+	return func(w *Watcher) error {
+		if d <= 0 {
+			return errors.New("WithMaxConnLifetime: lifetime must be positive")
+		}
+		w.maxLifetime = d
+		return nil
+	}
+}
+
+// startExpiryLocked starts `expireConns` if `WithMaxConnLifetime` is set and it is
+// not already running. w.mu must be held.
+func (w *Watcher) startExpiryLocked() {
+	if w.maxLifetime == 0 || w.expiryStop != nil || w.closed {
+		return
+	}
+	w.expiryStop = make(chan struct{})
+	go w.expireConns(w.expiryStop, w.closedChanLocked())
+}
+
+// stopExpiryLocked stops `expireConns` if it is running. w.mu must be held.
+func (w *Watcher) stopExpiryLocked() {
+	if w.expiryStop != nil {
+		close(w.expiryStop)
+		w.expiryStop = nil
+	}
+}
+
+// expiredLocked reports whether c has outlived the `WithMaxConnLifetime` limit. w.mu
+// must be held.
+func (w *Watcher) expiredLocked(c net.Conn) bool {
+	info, ok := w.conns[c]
+	return ok && w.maxLifetime > 0 && w.clock.Now().Sub(info.opened) >= w.maxLifetime
+}
+
+// expireConns closes expired connections that have no request in progress, sleeping
+// until the next one is due to expire, until stop or closed is closed.
+func (w *Watcher) expireConns(stop, closed <-chan struct{}) {
+	for {
+		w.mu.Lock()
+		clock, wait := w.clock, w.maxLifetime
+		now := clock.Now()
+		var expired []net.Conn
+		for c, info := range w.conns {
+			if !info.stateSeen || info.state == http.StateActive || info.state == http.StateHijacked {
+				// Active conns are closed by RecordConnStateConn once they go idle.
+				continue
+			}
+			age := now.Sub(info.opened)
+			if age >= w.maxLifetime {
+				expired = append(expired, c)
+			} else if w.maxLifetime-age < wait {
+				wait = w.maxLifetime - age
+			}
+		}
+		w.mu.Unlock()
+		for _, c := range expired {
+			// The server then records StateClosed.
+			_ = c.Close()
+		}
+		select {
+		case <-stop:
+			return
+		case <-closed:
+			return
+		case <-clock.After(wait):
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package httpdshutdown
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// waitForConns waits up to a second for w to have n open connections.
+func waitForConns(w *Watcher, n int64) bool {
+	deadline := time.Now().Add(time.Second)
+	for w.ActiveConns() != n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+func TestMaxConnLifetime(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000)
+	defer w.Close()
+	if err := w.Configure(WithMaxConnLifetime(time.Minute)); err != nil {
+		t.Fatalf("TestMaxConnLifetime: should configure, got %v", err)
+	}
+	ts := httptest.NewUnstartedServer(http.NotFoundHandler())
+	ts.Config.ConnState = w.RecordConnStateConn
+	ts.Start()
+	defer ts.Close()
+
+	client, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("TestMaxConnLifetime: should dial, got %v", err)
+	}
+	defer client.Close()
+	if !waitForConns(w, 1) {
+		t.Fatalf("TestMaxConnLifetime: conn should be recorded")
+	}
+	clock.BlockUntil(1)
+	clock.Advance(30 * time.Second)
+	if !waitForConns(w, 1) {
+		t.Errorf("TestMaxConnLifetime: conn should not be closed before the limit")
+	}
+	clock.Advance(30 * time.Second)
+	if !waitForConns(w, 0) {
+		t.Fatalf("TestMaxConnLifetime: conn should be closed past the limit")
+	}
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("TestMaxConnLifetime: client should see the conn closed, got %v", err)
+	}
+}
+
+func TestMaxConnLifetimeActive(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000)
+	defer w.Close()
+	if err := w.Configure(WithMaxConnLifetime(time.Minute)); err != nil {
+		t.Fatalf("TestMaxConnLifetimeActive: should configure, got %v", err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		rw.WriteHeader(http.StatusOK)
+	}))
+	ts.Config.ConnState = w.RecordConnStateConn
+	ts.Start()
+	defer ts.Close()
+
+	codes := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			codes <- 0
+			return
+		}
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}()
+	<-started
+	clock.BlockUntil(1)
+	clock.Advance(2 * time.Minute)
+	clock.BlockUntil(1) // rechecked, and the active conn left open
+	if n := w.ActiveConns(); n != 1 {
+		t.Errorf("TestMaxConnLifetimeActive: conn with a request in progress should stay open, got %d", n)
+	}
+	close(release)
+	if code := <-codes; code != http.StatusOK {
+		t.Errorf("TestMaxConnLifetimeActive: request should complete, got %d", code)
+	}
+	if !waitForConns(w, 0) {
+		t.Errorf("TestMaxConnLifetimeActive: conn should be closed once the request is done")
+	}
+	if w.Configure(WithMaxConnLifetime(0)) == nil {
+		t.Errorf("TestMaxConnLifetimeActive: should have error for a zero lifetime")
+	}
+}
+
+func TestMaxConnLifetimeLazy(t *testing.T) {
+	w, _ := newFakeClockWatcher(t, 3000)
+	running := func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.expiryStop != nil
+	}
+	if w.Configure(WithMaxConnLifetime(time.Minute), WithMaxConnLifetime(0)) == nil {
+		t.Fatalf("TestMaxConnLifetimeLazy: should have error for a zero lifetime")
+	}
+	if running() {
+		t.Errorf("TestMaxConnLifetimeLazy: options should not start checking")
+	}
+	w.Accepting(true)
+	if !running() {
+		t.Errorf("TestMaxConnLifetimeLazy: should start checking once accepting")
+	}
+	if err := w.OnStop(); err != nil {
+		t.Fatalf("TestMaxConnLifetimeLazy: should stop cleanly, got %v", err)
+	}
+	if running() {
+		t.Errorf("TestMaxConnLifetimeLazy: should stop checking after OnStop")
+	}
+	_ = w.Reset()
+	w.Accepting(true)
+	if err := w.Reset(); err != nil || running() {
+		t.Errorf("TestMaxConnLifetimeLazy: should stop checking after Reset")
+	}
+}
@@ -0,0 +1,143 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"sync"
+)
+
+// TrackListener wraps l so that every connection it accepts is counted with
+// `ConnOpened` and, once closed, `ConnClosed`. This drives the Watcher from servers
+// that have no `ConnState` callback, such as a FastCGI responder; see `ServeFastCGI`.
+// Servers that do have one should use `RecordConnState` instead. That includes h2c
+// (HTTP/2 cleartext) servers, whether built with golang.org/x/net/http2/h2c or, from
+// Go 1.24, with `http.Server`'s own support:
+//
+//	protocols := new(http.Protocols)
+//	protocols.SetHTTP1(true)
+//	protocols.SetUnencryptedHTTP2(true)
+//	srv := &http.Server{
+//	        Addr:      ":8080",
+//	        Handler:   watcher.TrackHandler(mux),
+//	        Protocols: protocols,
+//	        ConnState: watcher.RecordConnStateConn,
+//	}
+//
+// With HTTP/2 many requests share one connection, so pair either with
+// `TrackHandler` to wait for the requests themselves.
+func (w *Watcher) TrackListener(l net.Listener) net.Listener {
+	if w == nil {
+		panic("TrackListener: receiver is nil")
+	}
+	return &trackedListener{Listener: l, w: w}
+}
+
+// ServeFastCGI serves FastCGI requests on l with `fcgi.Serve`, counting connections
+// with `TrackListener` and in-flight requests with `TrackHandler`. It returns when l
+// is closed.
+//
+// Example use:
+//
+//	l, err := net.Listen("tcp", "127.0.0.1:9000")
+//	...
+//	go watcher.SigHandle(sigs, exitcode)
+//	err = watcher.ServeFastCGI(l, mux)
+func (w *Watcher) ServeFastCGI(l net.Listener, handler http.Handler) error {
+	if w == nil {
+		panic("ServeFastCGI: receiver is nil")
+	}
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	return fcgi.Serve(w.TrackListener(l), w.TrackHandler(handler))
+}
+
+// ManageListener makes `OnStop` close l as soon as the drain begins, so no new
+// connections arrive on it, for listeners not served by a managed `http.Server`,
+// such as one passed to `ServeFastCGI` or a UNIX domain socket for internal APIs.
+//
+// Example use:
+//
+//	l, err := net.Listen("unix", "/run/myapp/api.sock")
+//	...
+//	err = watcher.ManageListener(l)
+//	err = watcher.Configure(httpdshutdown.WithUnixSocketCleanup("/run/myapp/api.sock"))
+func (w *Watcher) ManageListener(l net.Listener) error {
+	if w == nil {
+		return errors.New("ManageListener: receiver is nil")
+	}
+	if l == nil {
+		return errors.New("ManageListener: listener is nil")
+	}
+	w.mu.Lock()
+	w.listeners = append(w.listeners, l)
+	w.mu.Unlock()
+	return nil
+}
+
+// closeListeners closes the managed listeners.
+func (w *Watcher) closeListeners() {
+	w.mu.Lock()
+	listeners := make([]net.Listener, len(w.listeners))
+	copy(listeners, w.listeners)
+	w.mu.Unlock()
+	for _, l := range listeners {
+		err := l.Close()
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			w.logf("closeListeners: could not close %v: %v", l.Addr(), err)
+		}
+	}
+}
+
+// WithUnixSocketCleanup registers a shutdown hook that removes the UNIX domain socket
+// file at path, which is otherwise left behind when the listener did not create it,
+// such as one inherited from systemd or a previous instance. A missing file is not
+// an error.
+func WithUnixSocketCleanup(path string) Option {
+	return func(w *Watcher) error {
+		if path == "" {
+			return errors.New("WithUnixSocketCleanup: path is empty")
+		}
+		w.shutdownHooks = append(w.shutdownHooks, hook{name: "remove " + path, run: func(context.Context, <-chan struct{}) error {
+			err := os.Remove(path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			return nil
+		}})
+		return nil
+	}
+}
+
+// trackedListener counts the connections accepted by the net.Listener it wraps.
+type trackedListener struct {
+	net.Listener
+	w *Watcher
+}
+
+func (l *trackedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.w.ConnOpened()
+	return &trackedConn{Conn: c, w: l.w}, nil
+}
+
+// trackedConn counts itself closed the first time it is closed.
+type trackedConn struct {
+	net.Conn
+	w    *Watcher
+	once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.w.ConnClosed)
+	return err
+}
@@ -0,0 +1,131 @@
+package httpdshutdown
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTrackListener(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestTrackListener: should not be nil")
+	}
+	w.Accepting(true)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TestTrackListener: %v", err)
+	}
+	tl := w.TrackListener(ln)
+	defer tl.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := tl.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("TestTrackListener: %v", err)
+	}
+	defer client.Close()
+	c := <-accepted
+	if w.ActiveConns() != 1 {
+		t.Errorf("TestTrackListener: should count the accepted conn, got %d", w.ActiveConns())
+	}
+	c.Close()
+	c.Close()
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestTrackListener: should count the conn closed once, got %d", w.ActiveConns())
+	}
+}
+
+func TestH2CConnAccounting(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestH2CConnAccounting: should not be nil")
+	}
+	w.Accepting(true)
+	release := make(chan struct{})
+	handler := w.TrackHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	// An h2c server reports one conn, active while any of its streams is open, and
+	// serves each stream's request concurrently on it.
+	c, peer := net.Pipe()
+	defer peer.Close()
+	w.RecordConnStateConn(c, http.StateNew)
+	w.RecordConnStateConn(c, http.StateActive)
+
+	const n = 3
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for w.InFlightRequests() < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if w.InFlightRequests() != n || w.ActiveConns() != 1 {
+		t.Errorf("TestH2CConnAccounting: should have %d requests on 1 conn, got %d on %d",
+			n, w.InFlightRequests(), w.ActiveConns())
+	}
+	close(release)
+	wg.Wait()
+	w.RecordConnStateConn(c, http.StateIdle)
+	w.RecordConnStateConn(c, http.StateClosed)
+	if w.ActiveConns() != 0 || w.InFlightRequests() != 0 {
+		t.Errorf("TestH2CConnAccounting: should drain, got %d conns and %d requests",
+			w.ActiveConns(), w.InFlightRequests())
+	}
+}
+
+func TestUnixSocketCleanup(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestUnixSocketCleanup: should not be nil")
+	}
+	path := filepath.Join(t.TempDir(), "api.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("TestUnixSocketCleanup: %v", err)
+	}
+	// As for a listener inherited from elsewhere, which leaves the file behind.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	err = w.ManageListener(l)
+	if err != nil {
+		t.Fatalf("TestUnixSocketCleanup: should not have error")
+	}
+	err = w.Configure(WithUnixSocketCleanup(path))
+	if err != nil {
+		t.Fatalf("TestUnixSocketCleanup: should not have error")
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- http.Serve(l, http.NotFoundHandler())
+	}()
+
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestUnixSocketCleanup: should not have error: %v", err)
+	}
+	if err := <-served; err == nil {
+		t.Errorf("TestUnixSocketCleanup: listener should be closed")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("TestUnixSocketCleanup: socket file should be removed, got %v", err)
+	}
+	if w.ManageListener(nil) == nil || w.Configure(WithUnixSocketCleanup("")) == nil {
+		t.Errorf("TestUnixSocketCleanup: should reject nil and empty arguments")
+	}
+}
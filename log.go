@@ -0,0 +1,77 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// WithLogger sets the logger the Watcher reports through. By default the Watcher
+// does not log.
+func WithLogger(logger *log.Logger) Option {
+	return func(w *Watcher) error {
+		if logger == nil {
+			return errors.New("WithLogger: logger is nil")
+		}
+		w.logger = logger
+		return nil
+	}
+}
+
+// logf writes to the configured logger, if there is one.
+func (w *Watcher) logf(format string, v ...interface{}) {
+	w.mu.Lock()
+	logger := w.logger
+	w.mu.Unlock()
+	if logger != nil {
+		logger.Printf(format, v...)
+	}
+}
+
+// WithConnStateLogging makes the Watcher log every connection state it records, with
+// the resulting count of open connections, through the logger set with `WithLogger`.
+// It is meant for diagnosing drains and is off by default.
+func WithConnStateLogging(enabled bool) Option {
+	return func(w *Watcher) error {
+		w.connStateLogging = enabled
+		w.updateFastPathLocked()
+		return nil
+	}
+}
+
+// WithLogRateLimit limits the lines logged by `WithConnStateLogging` to one per
+// interval, so a drain of many connections cannot flood the log. Lines logged within
+// the interval are dropped, and the next line logged reports how many were, with the
+// open connection count it shows still being current. Zero means no limit.
+func WithLogRateLimit(interval time.Duration) Option {
+	return func(w *Watcher) error {
+		if interval < 0 {
+			return errors.New("WithLogRateLimit: interval must not be negative")
+		}
+		w.logInterval = interval
+		return nil
+	}
+}
+
+// logConnStateLocked logs a recorded state if `WithConnStateLogging` is enabled,
+// subject to `WithLogRateLimit`. w.mu must be held.
+func (w *Watcher) logConnStateLocked(caller string, state http.ConnState) {
+	if !w.connStateLogging || w.logger == nil {
+		return
+	}
+	if w.logInterval > 0 {
+		now := w.clock.Now()
+		if !w.lastLogged.IsZero() && now.Sub(w.lastLogged) < w.logInterval {
+			w.suppressedLogs++
+			return
+		}
+		w.lastLogged = now
+	}
+	if w.suppressedLogs > 0 {
+		w.logger.Printf("%s: state=%v active=%d suppressed=%d", caller, state, w.activeConns.Load(), w.suppressedLogs)
+		w.suppressedLogs = 0
+		return
+	}
+	w.logger.Printf("%s: state=%v active=%d", caller, state, w.activeConns.Load())
+}
@@ -0,0 +1,200 @@
+package httpdshutdown
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newLoggedWatcher(t *testing.T, timeoutMS int, hooks ...ShutdownHook) (*Watcher, *fakeClock, *bytes.Buffer) {
+	w, clock := newFakeClockWatcher(t, timeoutMS, hooks...)
+	buf := new(bytes.Buffer)
+	err := w.Configure(WithLogger(log.New(buf, "", 0)))
+	if err != nil {
+		t.Fatalf("newLoggedWatcher: should not have error")
+	}
+	return w, clock, buf
+}
+
+func TestShutdownSummary(t *testing.T) {
+	w, clock, buf := newLoggedWatcher(t, 3000, sampleShutdownHook, func() error {
+		return errors.New("hook failed")
+	})
+	w.RecordConn(true)
+	w.RecordConn(true)
+	w.RecordConn(true)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	w.RecordConn(false)
+	w.RecordConn(false)
+	clock.Advance(3 * time.Second)
+	<-errChan
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	summary := lines[len(lines)-1]
+	for _, field := range []string{"duration=3s", "drained=2", "force_closed=1", "hooks_run=2", "hooks_failed=1", "timed_out=true"} {
+		if !strings.Contains(summary, field) {
+			t.Errorf("TestShutdownSummary: summary %q should contain %q", summary, field)
+		}
+	}
+}
+
+func TestWithLoggerNil(t *testing.T) {
+	w, _ := NewWatcher(1000)
+	if w.Configure(WithLogger(nil)) == nil {
+		t.Errorf("TestWithLoggerNil: should have error")
+	}
+}
+
+func TestNeverAcceptingWarning(t *testing.T) {
+	const warning = "never accepting"
+	w, _, buf := newLoggedWatcher(t, 3000)
+	err := w.OnStop()
+	if err != nil {
+		t.Errorf("TestNeverAcceptingWarning: should not have error")
+	}
+	if !strings.Contains(buf.String(), warning) {
+		t.Errorf("TestNeverAcceptingWarning: should warn, got %q", buf.String())
+	}
+
+	buf.Reset()
+	err = w.Reset()
+	if err != nil {
+		t.Fatalf("TestNeverAcceptingWarning: should not have error")
+	}
+	w.Accepting(true)
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestNeverAcceptingWarning: should not have error")
+	}
+	if strings.Contains(buf.String(), warning) {
+		t.Errorf("TestNeverAcceptingWarning: should not warn after accepting, got %q", buf.String())
+	}
+}
+
+func TestUnwiredWarning(t *testing.T) {
+	const warning = "nothing to drain and no hooks"
+	w, _, buf := newLoggedWatcher(t, 3000)
+	w.Accepting(true)
+	err := w.OnStop()
+	if err != nil {
+		t.Errorf("TestUnwiredWarning: should not have error")
+	}
+	if !strings.Contains(buf.String(), warning) {
+		t.Errorf("TestUnwiredWarning: should warn, got %q", buf.String())
+	}
+
+	buf.Reset()
+	_ = w.Reset()
+	_ = w.AddNamedHook("cleanup", sampleShutdownHook)
+	w.Accepting(true)
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestUnwiredWarning: should not have error")
+	}
+	if strings.Contains(buf.String(), warning) {
+		t.Errorf("TestUnwiredWarning: should not warn with a hook, got %q", buf.String())
+	}
+}
+
+func TestConnStateLogging(t *testing.T) {
+	w, _, buf := newLoggedWatcher(t, 3000)
+	w.RecordConnState(http.StateNew)
+	if buf.Len() != 0 {
+		t.Errorf("TestConnStateLogging: should be silent by default, got %q", buf.String())
+	}
+	err := w.Configure(WithConnStateLogging(true))
+	if err != nil {
+		t.Fatalf("TestConnStateLogging: should not have error")
+	}
+	w.RecordConnState(http.StateNew)
+	w.RecordConnState(http.StateActive)
+	w.RecordConnState(http.StateIdle)
+	w.RecordConnState(http.StateClosed)
+	want := []string{
+		"RecordConnState: state=new active=2",
+		"RecordConnState: state=active active=2",
+		"RecordConnState: state=idle active=2",
+		"RecordConnState: state=closed active=1",
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("TestConnStateLogging: should log %d lines, got %q", len(want), buf.String())
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("TestConnStateLogging: line %d should be %q, got %q", i, want[i], lines[i])
+		}
+	}
+}
+
+func TestLogRateLimit(t *testing.T) {
+	w, clock, buf := newLoggedWatcher(t, 3000)
+	err := w.Configure(WithConnStateLogging(true), WithLogRateLimit(time.Second))
+	if err != nil {
+		t.Fatalf("TestLogRateLimit: should not have error")
+	}
+	for i := 0; i < 100; i++ {
+		w.RecordConnState(http.StateNew)
+	}
+	clock.Advance(500 * time.Millisecond)
+	for i := 0; i < 100; i++ {
+		w.RecordConnState(http.StateClosed)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Errorf("TestLogRateLimit: should log one line per interval, got %d", len(lines))
+	}
+	clock.Advance(500 * time.Millisecond)
+	w.RecordConnState(http.StateNew)
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || lines[1] != "RecordConnState: state=new active=1 suppressed=199" {
+		t.Errorf("TestLogRateLimit: should report the suppressed lines, got %q", lines)
+	}
+	if w.Configure(WithLogRateLimit(-time.Second)) == nil {
+		t.Errorf("TestLogRateLimit: should reject a negative interval")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	ran := false
+	w, _, buf := newLoggedWatcher(t, 3000, func() error {
+		ran = true
+		return nil
+	})
+	_ = w.AddNamedHook("close-db", sampleShutdownHook)
+	err := w.Configure(WithDryRun(true))
+	if err != nil {
+		t.Fatalf("TestDryRun: should not have error")
+	}
+	w.Accepting(true)
+	w.RecordConn(true)
+	w.RecordConn(true)
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestDryRun: should not have error: %v", err)
+	}
+	if ran {
+		t.Errorf("TestDryRun: hooks should not run")
+	}
+	if !w.IsAccepting() || w.IsShuttingDown() || w.ActiveConns() != 2 {
+		t.Errorf("TestDryRun: nothing should be torn down")
+	}
+	for _, line := range []string{
+		"would drain 2 connections to 0 with grace period 3s",
+		"would shut down 0 managed servers",
+		"would run hook 1: ",
+		"would run hook 2: close-db",
+	} {
+		if !strings.Contains(buf.String(), "OnStop: dry run: "+line) {
+			t.Errorf("TestDryRun: log should contain %q, got %q", line, buf.String())
+		}
+	}
+}
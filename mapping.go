@@ -0,0 +1,62 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ConnDelta is how a connection state changes the count of open connections.
+type ConnDelta int
+
+const (
+	ConnUnchanged ConnDelta = iota // The state does not change the count.
+	ConnOpen                       // The connection is counted as open.
+	ConnDone                       // The connection is counted as closed.
+)
+
+// WithConnStateMapping sets how `RecordConnState` counts each connection state, for
+// servers and middlewares that report connections unusually, such as never emitting
+// `http.StateClosed`. States missing from mapping leave the count unchanged. By
+// default `http.StateNew` opens a connection and `http.StateClosed` and
+// `http.StateHijacked` close it.
+//
+// `RecordConnStateConn`, which managed servers use, applies the mapping per
+// connection: a connection is counted once however many of its states open it, and
+// uncounted once however many close it. As it can tell when a connection is gone,
+// `http.StateClosed` and `http.StateHijacked` always uncount it there.
+//
+// Example use:
+//
+//	err := watcher.Configure(httpdshutdown.WithConnStateMapping(map[http.ConnState]httpdshutdown.ConnDelta{
+//	        http.StateNew:    httpdshutdown.ConnOpen,
+//	        http.StateActive: httpdshutdown.ConnOpen,
+//	        http.StateIdle:   httpdshutdown.ConnDone,
+//	}))
+func WithConnStateMapping(mapping map[http.ConnState]ConnDelta) Option {
+	return func(w *Watcher) error {
+		connStateMapping := make(map[http.ConnState]ConnDelta, len(mapping))
+		for state, delta := range mapping {
+			if delta < ConnUnchanged || delta > ConnDone {
+				return errors.New("WithConnStateMapping: unknown delta for " + state.String())
+			}
+			connStateMapping[state] = delta
+		}
+		w.connStateMapping = connStateMapping
+		w.updateFastPathLocked()
+		return nil
+	}
+}
+
+// connDeltaLocked returns how state changes the count. w.mu must be held.
+func (w *Watcher) connDeltaLocked(state http.ConnState) ConnDelta {
+	if w.connStateMapping != nil {
+		return w.connStateMapping[state]
+	}
+	switch state {
+	case http.StateNew:
+		return ConnOpen
+	case http.StateClosed, http.StateHijacked:
+		return ConnDone
+	}
+	return ConnUnchanged
+}
@@ -0,0 +1,77 @@
+package httpdshutdown
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestConnStateMapping(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestConnStateMapping: should not be nil")
+	}
+	err := w.Configure(WithConnStateMapping(map[http.ConnState]ConnDelta{
+		http.StateNew:  ConnOpen,
+		http.StateIdle: ConnDone,
+	}))
+	if err != nil {
+		t.Fatalf("TestConnStateMapping: should not have error")
+	}
+	w.RecordConnState(http.StateNew)
+	w.RecordConnState(http.StateActive)
+	if w.ActiveConns() != 1 {
+		t.Errorf("TestConnStateMapping: should count the new conn, got %d", w.ActiveConns())
+	}
+	w.RecordConnState(http.StateIdle)
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestConnStateMapping: idle should count as done, got %d", w.ActiveConns())
+	}
+	w.RecordConnState(http.StateNew)
+	w.RecordConnState(http.StateClosed)
+	if w.ActiveConns() != 1 {
+		t.Errorf("TestConnStateMapping: closed should not be counted, got %d", w.ActiveConns())
+	}
+	if w.Configure(WithConnStateMapping(map[http.ConnState]ConnDelta{http.StateNew: 7})) == nil {
+		t.Errorf("TestConnStateMapping: should reject an unknown delta")
+	}
+}
+
+func TestConnStateMappingConn(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestConnStateMappingConn: should not be nil")
+	}
+	// Only count conns with a request in progress, as a managed server reports them.
+	err := w.Configure(WithConnStateMapping(map[http.ConnState]ConnDelta{
+		http.StateActive: ConnOpen,
+		http.StateIdle:   ConnDone,
+	}))
+	if err != nil {
+		t.Fatalf("TestConnStateMappingConn: should not have error")
+	}
+	c, peer := net.Pipe()
+	defer c.Close()
+	defer peer.Close()
+	w.RecordConnStateConn(c, http.StateNew)
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestConnStateMappingConn: new should not be counted, got %d", w.ActiveConns())
+	}
+	w.RecordConnStateConn(c, http.StateActive)
+	w.RecordConnStateConn(c, http.StateActive)
+	if w.ActiveConns() != 1 {
+		t.Errorf("TestConnStateMappingConn: active should count the conn once, got %d", w.ActiveConns())
+	}
+	w.RecordConnStateConn(c, http.StateIdle)
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestConnStateMappingConn: idle should count as done, got %d", w.ActiveConns())
+	}
+	w.RecordConnStateConn(c, http.StateActive)
+	w.RecordConnStateConn(c, http.StateClosed)
+	if w.ActiveConns() != 0 || len(w.LeakedConns()) != 0 {
+		t.Errorf("TestConnStateMappingConn: closed should forget the conn, got %d", w.ActiveConns())
+	}
+	if err := w.Validate(); err != nil {
+		t.Errorf("TestConnStateMappingConn: counts should balance, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	nilReceiverNoOp   atomic.Bool // Set with SetNilReceiverNoOp.
+	nilReceiverWarned sync.Once
+)
+
+// SetNilReceiverNoOp makes the connection callbacks `RecordConnState`,
+// `RecordConnStateConn`, `RecordConn`, `ConnOpened`, `ConnClosed`, `ConnContext` and
+// `TryAccept`, and the worker callbacks `WorkerStarted` and `WorkerFinished`, do
+// nothing when called on a nil Watcher, instead of panicking; `ConnContext` returns
+// its context unchanged and `TryAccept` returns false. The first such call is logged
+// with the standard logger. This is a package-level setting as there is no Watcher
+// to configure; it suits embedders that cannot tolerate a panic in a server callback
+// under any circumstances, at the price of wiring mistakes going unnoticed.
+//
+// Its scope is deliberately narrow: only the calls made for every connection or job,
+// long after the Watcher was wired up. Methods that wire it up, such as
+// `TrackHandler`, `TrackListener`, `NewManagedServer` or `StatusHandler`, still panic
+// on a nil Watcher, so the mistake shows at startup.
+//
+// Example use:
+//
+//	func init() {
+//	        httpdshutdown.SetNilReceiverNoOp(true)
+//	}
+func SetNilReceiverNoOp(noOp bool) {
+	nilReceiverNoOp.Store(noOp)
+}
+
+// nilReceiver handles a connection or worker callback called on a nil Watcher,
+// panicking unless `SetNilReceiverNoOp(true)` was called.
+func nilReceiver(method string) {
+	if !nilReceiverNoOp.Load() {
+		// we panic here instead of returning nil as the calling context does not
+		// do any error checking
+		panic(method + ": receiver is nil")
+	}
+	nilReceiverWarned.Do(func() {
+		log.Printf("%s: receiver is nil, ignoring connection and worker callbacks", method)
+	})
+}
+
+// nilReceiverResult returns the result channel for a method called on a nil Watcher
+// that reports its outcome on a channel, already holding the error.
+func nilReceiverResult(method string) <-chan error {
+	result := make(chan error, 1)
+	result <- errors.New(method + ": receiver is nil")
+	return result
+}
@@ -0,0 +1,83 @@
+package httpdshutdown
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNilReceiverPanics(t *testing.T) {
+	var w *Watcher
+	defer func() {
+		if recover() == nil {
+			t.Errorf("TestNilReceiverPanics: should panic by default")
+		}
+	}()
+	w.RecordConnState(http.StateNew)
+}
+
+func TestNilReceiverNoOp(t *testing.T) {
+	nilReceiverWarned = sync.Once{} // warn again under -count
+	buf := new(bytes.Buffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	SetNilReceiverNoOp(true)
+	defer SetNilReceiverNoOp(false)
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("TestNilReceiverNoOp: should not panic, got %v", r)
+		}
+	}()
+	var w *Watcher
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	w.RecordConnState(http.StateNew)
+	w.RecordConnStateConn(c1, http.StateNew)
+	w.RecordConn(true)
+	w.ConnOpened()
+	w.ConnClosed()
+	w.WorkerStarted()
+	w.WorkerFinished()
+	if w.ConnContext(context.Background(), c1) == nil {
+		t.Errorf("TestNilReceiverNoOp: ConnContext should return the context")
+	}
+	if w.TryAccept() {
+		t.Errorf("TestNilReceiverNoOp: TryAccept should refuse")
+	}
+	if !strings.Contains(buf.String(), "RecordConnState: receiver is nil") {
+		t.Errorf("TestNilReceiverNoOp: should log a warning, got %q", buf.String())
+	}
+}
+
+func TestNilReceiverResults(t *testing.T) {
+	var w *Watcher
+	results := map[string]<-chan error{
+		"WatchContext":           w.WatchContext(context.Background()),
+		"Handoff":                w.Handoff(make(chan struct{})),
+		"TriggerShutdown":        w.TriggerShutdown(),
+		"TriggerShutdownRequest": w.TriggerShutdownRequest(httptest.NewRequest("POST", "/shutdown", nil)),
+	}
+	for method, result := range results {
+		err := <-result
+		if err == nil || !strings.Contains(err.Error(), method+": receiver is nil") {
+			t.Errorf("TestNilReceiverResults: %s should send an error, got %v", method, err)
+		}
+	}
+}
+
+func TestNilReceiverDone(t *testing.T) {
+	var w *Watcher
+	select {
+	case <-w.Done():
+	default:
+		t.Errorf("TestNilReceiverDone: Done should be closed")
+	}
+}
@@ -0,0 +1,39 @@
+package httpdshutdown
+
+import "errors"
+
+// Option configures optional Watcher behavior. Options are applied with `Configure`.
+type Option func(*Watcher) error
+
+// Configure applies options to the Watcher. Options should be applied before the
+// daemon starts serving.
+//
+// Example use:
+//
+//	err := watcher.Configure(httpdshutdown.WithMaxConns(1024))
+func (w *Watcher) Configure(opts ...Option) error {
+	if w == nil {
+		return errors.New("Configure: receiver is nil")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, opt := range opts {
+		err := opt(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithMaxConns caps the number of connections `TryAccept` will admit. Zero means
+// no cap.
+func WithMaxConns(n int) Option {
+	return func(w *Watcher) error {
+		if n < 0 {
+			return errors.New("WithMaxConns: max conns must not be negative")
+		}
+		w.maxConns = int64(n)
+		return nil
+	}
+}
@@ -0,0 +1,95 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// AddHookToPhase registers a hook to run at shutdown in the given phase. Phases run
+// in ascending order once the hooks registered without a phase have run, and the
+// hooks within a phase run concurrently. This models teardown with dependencies,
+// such as flushing application state in one phase before closing the database in
+// the next.
+//
+// Example use:
+//
+//	err := watcher.AddHookToPhase(1, flushState)
+//	err = watcher.AddHookToPhase(1, flushMetrics)
+//	err = watcher.AddHookToPhase(2, closeDB)
+func (w *Watcher) AddHookToPhase(phase int, f ShutdownHook) error {
+	if w == nil {
+		return errors.New("AddHookToPhase: receiver is nil")
+	}
+	if f == nil {
+		return errors.New("AddHookToPhase: hook is nil")
+	}
+	w.addHook(hook{name: funcName(f), run: f.hookFunc(), phased: true, phase: phase})
+	return nil
+}
+
+// hookBatches splits hooks into the batches they run in: each hook registered
+// without a phase is a batch of its own, in registration order, followed by one
+// batch per phase in ascending order.
+func hookBatches(hooks []hook) [][]hook {
+	batches := make([][]hook, 0, len(hooks))
+	phased := make([]hook, 0)
+	for _, h := range hooks {
+		if h.phased {
+			phased = append(phased, h)
+			continue
+		}
+		batches = append(batches, []hook{h})
+	}
+	sort.SliceStable(phased, func(i, j int) bool {
+		return phased[i].phase < phased[j].phase
+	})
+	for i, h := range phased {
+		if i == 0 || h.phase != phased[i-1].phase {
+			batches = append(batches, nil)
+		}
+		batches[len(batches)-1] = append(batches[len(batches)-1], h)
+	}
+	return batches
+}
+
+// runBatch runs the hooks in batch concurrently, at most limit at a time unless it is
+// zero, and returns their results in order.
+func runBatch(ctx context.Context, stop <-chan struct{}, batch []hook, limit int) []HookResult {
+	results := make([]HookResult, len(batch))
+	if len(batch) == 1 {
+		results[0] = batch[0].runTimed(ctx, stop)
+		return results
+	}
+	var sem chan struct{}
+	if limit > 0 {
+		sem = make(chan struct{}, limit)
+	}
+	var wg sync.WaitGroup
+	for i, h := range batch {
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		wg.Add(1)
+		go func(i int, h hook) {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			results[i] = h.runTimed(ctx, stop)
+		}(i, h)
+	}
+	wg.Wait()
+	return results
+}
+
+// batchFailed reports whether any hook in a batch failed.
+func batchFailed(results []HookResult) bool {
+	for _, result := range results {
+		if result.Err != nil {
+			return true
+		}
+	}
+	return false
+}
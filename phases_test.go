@@ -0,0 +1,118 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHookPhases(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestHookPhases: should not be nil")
+	}
+	var mu sync.Mutex
+	order := make([]string, 0)
+	record := func(name string) {
+		mu.Lock()
+		order = append(order, name)
+		mu.Unlock()
+	}
+	// The two hooks in phase 2 only return once both are running.
+	var barrier sync.WaitGroup
+	barrier.Add(2)
+	concurrent := func(name string) ShutdownHook {
+		return func() error {
+			barrier.Done()
+			done := make(chan struct{})
+			go func() {
+				barrier.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				return errors.New(name + " ran alone")
+			}
+			record(name)
+			return nil
+		}
+	}
+	_ = w.AddHookToPhase(3, func() error {
+		record("close-db")
+		return nil
+	})
+	_ = w.AddHookToPhase(2, concurrent("flush-state"))
+	_ = w.AddHookToPhase(1, func() error {
+		record("stop-workers")
+		return nil
+	})
+	_ = w.AddHookToPhase(2, concurrent("flush-metrics"))
+	_ = w.AddNamedHook("unphased", func() error {
+		record("unphased")
+		return nil
+	})
+	if w.AddHookToPhase(1, nil) == nil {
+		t.Errorf("TestHookPhases: nil hook should be rejected")
+	}
+
+	err := w.OnStop()
+	if err != nil {
+		t.Fatalf("TestHookPhases: should not have error: %v", err)
+	}
+	if len(order) != 5 || order[0] != "unphased" || order[1] != "stop-workers" || order[4] != "close-db" {
+		t.Errorf("TestHookPhases: phases should run in ascending order, got %v", order)
+	}
+	if names := w.Hooks(); len(names) != 5 || names[0] != "unphased" {
+		t.Errorf("TestHookPhases: Hooks should list execution order, got %v", names)
+	}
+}
+
+func TestHookConcurrency(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestHookConcurrency: should not be nil")
+	}
+	const limit = 3
+	err := w.Configure(WithHookConcurrency(limit))
+	if err != nil {
+		t.Fatalf("TestHookConcurrency: should not have error")
+	}
+	var running, peak atomic.Int64
+	hook := func() error {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		running.Add(-1)
+		return nil
+	}
+	for i := 0; i < 10; i++ {
+		_ = w.AddHookToPhase(1, hook)
+	}
+	err = w.RunHooks()
+	if err != nil {
+		t.Errorf("TestHookConcurrency: should not have error: %v", err)
+	}
+	if got := peak.Load(); got != limit {
+		t.Errorf("TestHookConcurrency: should run %d hooks at once, got %d", limit, got)
+	}
+	peak.Store(0)
+	err = w.RunHooksGroup(context.Background())
+	if err != nil {
+		t.Errorf("TestHookConcurrency: should not have error: %v", err)
+	}
+	if got := peak.Load(); got > limit {
+		t.Errorf("TestHookConcurrency: RunHooksGroup should run at most %d hooks at once, got %d", limit, got)
+	}
+	if w.Configure(WithHookConcurrency(-1)) == nil {
+		t.Errorf("TestHookConcurrency: should reject a negative limit")
+	}
+}
@@ -0,0 +1,46 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+)
+
+// AddPreDrainHook registers a hook to be run at the very start of `OnStop`, before
+// the Watcher stops accepting and waits for connections, such as deregistering from
+// service discovery so new requests stop arriving. Pre-drain hooks run serially in
+// registration order before the grace period starts, so they should be quick. Their
+// results come first in `LastHookResults`, and other hooks still run after the drain.
+//
+// Example use:
+//
+//	err := watcher.AddPreDrainHook(func() error {
+//	        return registry.Deregister(serviceID)
+//	})
+func (w *Watcher) AddPreDrainHook(f ShutdownHook) error {
+	if w == nil {
+		return errors.New("AddPreDrainHook: receiver is nil")
+	}
+	if f == nil {
+		return errors.New("AddPreDrainHook: hook is nil")
+	}
+	w.mu.Lock()
+	w.preDrainHooks = append(w.preDrainHooks, f)
+	w.mu.Unlock()
+	return nil
+}
+
+// preDrainHookNames returns the names of the registered pre-drain hooks.
+func (w *Watcher) preDrainHookNames() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return shutdownHookNames(w.preDrainHooks)
+}
+
+// runPreDrainHooks runs the registered pre-drain hooks.
+func (w *Watcher) runPreDrainHooks(ctx context.Context) []HookResult {
+	w.mu.Lock()
+	preDrainHooks := make([]ShutdownHook, len(w.preDrainHooks))
+	copy(preDrainHooks, w.preDrainHooks)
+	w.mu.Unlock()
+	return runSerial(ctx, preDrainHooks)
+}
@@ -0,0 +1,60 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPreDrainHook(t *testing.T) {
+	var mu sync.Mutex
+	order := make([]string, 0)
+	record := func(event string) {
+		mu.Lock()
+		order = append(order, event)
+		mu.Unlock()
+	}
+	w, wErr := NewWatcher(3000, func() error {
+		record("post-drain")
+		return nil
+	})
+	if w == nil || wErr != nil {
+		t.Fatalf("TestPreDrainHook: should not be nil")
+	}
+	w.Accepting(true)
+	w.RecordConn(true)
+	err := w.AddPreDrainHook(func() error {
+		if !w.IsAccepting() || w.ActiveConns() != 1 {
+			t.Errorf("TestPreDrainHook: pre-drain hook should run before the drain")
+		}
+		record("pre-drain")
+		return errors.New("deregister failed")
+	})
+	if err != nil {
+		t.Fatalf("TestPreDrainHook: should not have error")
+	}
+	if w.AddPreDrainHook(nil) == nil {
+		t.Errorf("TestPreDrainHook: should reject a nil hook")
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		record("drained")
+		w.RecordConn(false)
+	}()
+	err = w.OnStop()
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) || len(shutdownErr.HookErrors) != 1 {
+		t.Errorf("TestPreDrainHook: should report the pre-drain hook error, got %v", err)
+	}
+	if want := []string{"pre-drain", "drained", "post-drain"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("TestPreDrainHook: should run in order %v, got %v", want, order)
+	}
+	if results := w.LastHookResults(); len(results) != 2 || results[0].Err == nil {
+		t.Errorf("TestPreDrainHook: pre-drain result should come first, got %v", results)
+	}
+	if names := w.Hooks(); len(names) != 2 {
+		t.Errorf("TestPreDrainHook: should list both hooks, got %v", names)
+	}
+}
@@ -0,0 +1,116 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// WithDrainProgress makes `OnStop` invoke fn every interval with the number of
+// connections still open, until the drain completes or times out. This keeps logs
+// from going silent during a long drain.
+func WithDrainProgress(interval time.Duration, fn func(remaining int64)) Option {
+	return func(w *Watcher) error {
+		if interval <= 0 {
+			return errors.New("WithDrainProgress: interval must be positive")
+		}
+		if fn == nil {
+			return errors.New("WithDrainProgress: callback is nil")
+		}
+		w.progressInterval = interval
+		w.progressFn = fn
+		return nil
+	}
+}
+
+// WithProgressJitter randomly varies each `WithDrainProgress` interval by up to
+// fraction of it in either direction, so a fleet of daemons signalled at once during
+// a deploy do not all report progress in lockstep. fraction must be in [0, 1); zero
+// disables jitter.
+func WithProgressJitter(fraction float64) Option {
+	return func(w *Watcher) error {
+		if fraction < 0 || fraction >= 1 {
+			return errors.New("WithProgressJitter: fraction must be in [0, 1)")
+		}
+		w.progressJitter = fraction
+		return nil
+	}
+}
+
+// jitterInterval returns interval varied by up to fraction of it in either
+// direction, using r, which is in [0, 1).
+func jitterInterval(interval time.Duration, fraction, r float64) time.Duration {
+	return time.Duration(float64(interval) * (1 + fraction*(2*r-1)))
+}
+
+// reportProgress calls fn every interval, varied by jitter, until done is closed.
+func (w *Watcher) reportProgress(clock Clock, interval time.Duration, jitter float64, fn func(int64), done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-clock.After(jitterInterval(interval, jitter, rand.Float64())):
+			fn(w.ActiveConns())
+		}
+	}
+}
+
+// drainUpdatesBuffer is how many counts a `DrainUpdates` channel holds before
+// further updates are dropped.
+const drainUpdatesBuffer = 64
+
+// DrainUpdates returns a channel that receives the number of open connections at
+// the start of the current or next drain, and again each time it changes. The
+// channel is closed once the drain completes, or when the Watcher is closed. Updates
+// are dropped rather than blocking the connections if the receiver falls far behind.
+//
+// Example use:
+//
+//	go func() {
+//	        for remaining := range watcher.DrainUpdates() {
+//	                bar.Set(remaining)
+//	        }
+//	}()
+func (w *Watcher) DrainUpdates() <-chan int64 {
+	updates := make(chan int64, drainUpdatesBuffer)
+	if w == nil {
+		close(updates)
+		return updates
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		close(updates)
+		return updates
+	}
+	if w.draining {
+		updates <- w.activeConns.Load()
+	}
+	w.drainSubs = append(w.drainSubs, updates)
+	return updates
+}
+
+// sendDrainUpdateLocked sends the open connection count to the `DrainUpdates`
+// channels during a drain. w.mu must be held.
+func (w *Watcher) sendDrainUpdateLocked() {
+	if !w.draining {
+		return
+	}
+	for _, updates := range w.drainSubs {
+		select {
+		case updates <- w.activeConns.Load():
+		default:
+		}
+	}
+}
+
+// endDrainUpdates closes the `DrainUpdates` channels once the drain is over.
+func (w *Watcher) endDrainUpdates() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.draining = false
+	for _, updates := range w.drainSubs {
+		close(updates)
+	}
+	w.drainSubs = nil
+}
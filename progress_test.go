@@ -0,0 +1,134 @@
+package httpdshutdown
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDrainProgress(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestDrainProgress: should not be nil")
+	}
+	var mu sync.Mutex
+	var counts []int64
+	err := w.Configure(WithDrainProgress(20*time.Millisecond, func(remaining int64) {
+		mu.Lock()
+		counts = append(counts, remaining)
+		mu.Unlock()
+	}))
+	if err != nil {
+		t.Fatalf("TestDrainProgress: should not have error")
+	}
+	w.RecordConn(true)
+	w.RecordConn(true)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		w.RecordConn(false)
+		time.Sleep(100 * time.Millisecond)
+		w.RecordConn(false)
+	}()
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestDrainProgress: should not have error")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(counts) < 2 {
+		t.Fatalf("TestDrainProgress: callback should fire at least twice, got %v", counts)
+	}
+	if counts[0] != 2 || counts[len(counts)-1] > 1 {
+		t.Errorf("TestDrainProgress: counts should decrease from 2, got %v", counts)
+	}
+	for i := 1; i < len(counts); i++ {
+		if counts[i] > counts[i-1] {
+			t.Errorf("TestDrainProgress: counts should not increase, got %v", counts)
+		}
+	}
+}
+
+func TestDrainProgressBadArgs(t *testing.T) {
+	w, _ := NewWatcher(3000)
+	if w.Configure(WithDrainProgress(0, func(int64) {})) == nil {
+		t.Errorf("TestDrainProgressBadArgs: should have error for zero interval")
+	}
+	if w.Configure(WithDrainProgress(time.Second, nil)) == nil {
+		t.Errorf("TestDrainProgressBadArgs: should have error for nil callback")
+	}
+}
+
+func TestProgressJitter(t *testing.T) {
+	w, _ := NewWatcher(3000)
+	if w.Configure(WithProgressJitter(-0.1)) == nil {
+		t.Errorf("TestProgressJitter: should have error for negative fraction")
+	}
+	if w.Configure(WithProgressJitter(1)) == nil {
+		t.Errorf("TestProgressJitter: should have error for fraction of one")
+	}
+	if err := w.Configure(WithProgressJitter(0.2)); err != nil {
+		t.Fatalf("TestProgressJitter: should accept 0.2, got %v", err)
+	}
+
+	interval := 100 * time.Millisecond
+	lo, hi := 80*time.Millisecond, 120*time.Millisecond
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 1000; i++ {
+		d := jitterInterval(interval, 0.2, rand.Float64())
+		if d < lo || d > hi {
+			t.Fatalf("TestProgressJitter: should stay within the jitter bound, got %v", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("TestProgressJitter: should vary the interval, got %d distinct values", len(seen))
+	}
+	if d := jitterInterval(interval, 0, rand.Float64()); d != interval {
+		t.Errorf("TestProgressJitter: should not vary without jitter, got %v", d)
+	}
+}
+
+func TestDrainUpdates(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000)
+	w.RecordConn(true)
+	w.RecordConn(true)
+	w.RecordConn(true)
+	updates := w.DrainUpdates()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	for i := 0; i < 3; i++ {
+		w.RecordConn(false)
+	}
+	got := make([]int64, 0)
+	for remaining := range updates {
+		got = append(got, remaining)
+	}
+	err := <-errChan
+	if err != nil {
+		t.Errorf("TestDrainUpdates: should not have error: %v", err)
+	}
+	want := []int64{3, 2, 1, 0}
+	if len(got) != len(want) {
+		t.Fatalf("TestDrainUpdates: should get %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("TestDrainUpdates: should get %v, got %v", want, got)
+			break
+		}
+	}
+}
+
+func TestDrainUpdatesClose(t *testing.T) {
+	w, _ := NewWatcher(1000)
+	updates := w.DrainUpdates()
+	_ = w.Close()
+	if _, ok := <-updates; ok {
+		t.Errorf("TestDrainUpdatesClose: should be closed without a drain")
+	}
+}
@@ -0,0 +1,81 @@
+package httpdshutdown
+
+import (
+	"context"
+	"net/http"
+)
+
+// watcherKey is the context key under which `InjectShutdownContext` stores the Watcher.
+type watcherKey struct{}
+
+// TrackHandler wraps next so that each request is counted as in flight from the
+// moment it enters the handler until the handler returns. With keep-alives and
+// HTTP/2 multiplexing one connection can carry many concurrent requests, so this is
+// a more accurate measure of outstanding work than the connection count.
+//
+// Example use:
+//
+//	srv := &http.Server{
+//	        Addr:      ":8080",
+//	        Handler:   watcher.TrackHandler(mux),
+//	        ConnState: watcher.RecordConnStateConn,
+//	}
+func (w *Watcher) TrackHandler(next http.Handler) http.Handler {
+	if w == nil {
+		panic("TrackHandler: receiver is nil")
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		w.mu.Lock()
+		w.inFlight++
+		w.mu.Unlock()
+		defer func() {
+			w.mu.Lock()
+			w.inFlight--
+			w.connsCond.Broadcast()
+			w.mu.Unlock()
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// InFlightRequests returns the number of requests currently inside a handler
+// wrapped by `TrackHandler`.
+func (w *Watcher) InFlightRequests() int64 {
+	if w == nil {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.inFlight
+}
+
+// InjectShutdownContext wraps next so that each request's context carries the
+// Watcher, letting handlers check `IsShuttingDownContext` and skip expensive work
+// once shutdown has begun.
+//
+// Example use:
+//
+//	mux.HandleFunc("/report", func(rw http.ResponseWriter, r *http.Request) {
+//	        if httpdshutdown.IsShuttingDownContext(r.Context()) {
+//	                http.Error(rw, "shutting down", http.StatusServiceUnavailable)
+//	                return
+//	        }
+//	        buildReport(rw)
+//	})
+//	srv.Handler = watcher.InjectShutdownContext(mux)
+func (w *Watcher) InjectShutdownContext(next http.Handler) http.Handler {
+	if w == nil {
+		panic("InjectShutdownContext: receiver is nil")
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), watcherKey{}, w)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+	})
+}
+
+// IsShuttingDownContext reports whether the Watcher stored in ctx by
+// `InjectShutdownContext` is shutting down. It is false if ctx has no Watcher.
+func IsShuttingDownContext(ctx context.Context) bool {
+	w, ok := ctx.Value(watcherKey{}).(*Watcher)
+	return ok && w.IsShuttingDown()
+}
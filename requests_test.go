@@ -0,0 +1,226 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTrackHandlerHTTP2(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestTrackHandlerHTTP2: should not be nil")
+	}
+	release := make(chan struct{})
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+	}
+	ts := httptest.NewUnstartedServer(w.TrackHandler(http.HandlerFunc(handler)))
+	ts.EnableHTTP2 = true
+	ts.Config.ConnState = func(conn net.Conn, newState http.ConnState) {
+		w.RecordConnState(newState)
+	}
+	ts.StartTLS()
+	defer ts.Close()
+	client := ts.Client()
+	// Establish the connection first so the concurrent requests reuse it.
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("TestTrackHandlerHTTP2: %v", err)
+	}
+	resp.Body.Close()
+
+	const n = 3
+	var wg sync.WaitGroup
+	protos := make(chan string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(ts.URL + "/slow")
+			if err != nil {
+				protos <- err.Error()
+				return
+			}
+			resp.Body.Close()
+			protos <- resp.Proto
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for w.InFlightRequests() < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := w.InFlightRequests(); got != n {
+		t.Errorf("TestTrackHandlerHTTP2: should have %d requests in flight, got %d", n, got)
+	}
+	if got := w.ActiveConns(); got != 1 {
+		t.Errorf("TestTrackHandlerHTTP2: requests should share one connection, got %d", got)
+	}
+	close(release)
+	wg.Wait()
+	close(protos)
+	for proto := range protos {
+		if proto != "HTTP/2.0" {
+			t.Errorf("TestTrackHandlerHTTP2: should use HTTP/2, got %s", proto)
+		}
+	}
+	if got := w.InFlightRequests(); got != 0 {
+		t.Errorf("TestTrackHandlerHTTP2: should have no requests in flight, got %d", got)
+	}
+}
+
+func TestOnStopWaitsForRequests(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000)
+	release := make(chan struct{})
+	handler := w.TrackHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	served := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		close(served)
+	}()
+	for w.InFlightRequests() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	select {
+	case <-errChan:
+		t.Errorf("TestOnStopWaitsForRequests: should wait for the in-flight request")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-served
+	err := <-errChan
+	if err != nil {
+		t.Errorf("TestOnStopWaitsForRequests: should not have error: %v", err)
+	}
+}
+
+func TestRequestsDrainWithIdleConn(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 1000)
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fast", func(rw http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/slow", func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	ts := httptest.NewUnstartedServer(w.TrackHandler(mux))
+	ts.Config.ConnState = func(conn net.Conn, newState http.ConnState) {
+		w.RecordConnState(newState)
+	}
+	ts.Start()
+	defer ts.Close()
+
+	// A keep-alive conn that has finished its request and stays open, idle.
+	idle := &http.Client{Transport: &http.Transport{}}
+	defer idle.CloseIdleConnections()
+	resp, err := idle.Get(ts.URL + "/fast")
+	if err != nil {
+		t.Fatalf("TestRequestsDrainWithIdleConn: %v", err)
+	}
+	resp.Body.Close()
+	// A second conn with a request in flight.
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		busy := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		if resp, err := busy.Get(ts.URL + "/slow"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	close(release)
+	<-served
+	if !waitForConns(w, 1) || w.InFlightRequests() != 0 {
+		t.Fatalf("TestRequestsDrainWithIdleConn: only the idle conn should remain, got %d conns and %d requests", w.ActiveConns(), w.InFlightRequests())
+	}
+	select {
+	case <-errChan:
+		t.Fatalf("TestRequestsDrainWithIdleConn: drain should still wait for the idle conn")
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	var shutdownErr *ShutdownError
+	if err := <-errChan; !errors.As(err, &shutdownErr) || !shutdownErr.TimedOut || shutdownErr.RemainingConns != 1 {
+		t.Errorf("TestRequestsDrainWithIdleConn: should time out on the idle conn alone, got %v", err)
+	}
+}
+
+func TestInjectShutdownContext(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestInjectShutdownContext: should not be nil")
+	}
+	if IsShuttingDownContext(context.Background()) {
+		t.Errorf("TestInjectShutdownContext: should be false without a Watcher")
+	}
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		if IsShuttingDownContext(r.Context()) {
+			http.Error(rw, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}
+	ts := httptest.NewUnstartedServer(w.InjectShutdownContext(w.TrackHandler(http.HandlerFunc(handler))))
+	ts.Config.ConnState = func(conn net.Conn, newState http.ConnState) {
+		w.RecordConnState(newState)
+	}
+	ts.Start()
+	defer ts.Close()
+	_ = w.ManageServer(ts.Config) // closes the idle keep-alive conn
+
+	get := func(codes chan<- int) {
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			codes <- 0
+			return
+		}
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}
+	codes := make(chan int, 1)
+	go get(codes)
+	<-started
+	release <- struct{}{}
+	if code := <-codes; code != http.StatusOK {
+		t.Errorf("TestInjectShutdownContext: should serve before shutdown, got %d", code)
+	}
+
+	go get(codes)
+	<-started
+	go func() {
+		for !w.IsShuttingDown() {
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+	}()
+	err := w.OnStop()
+	if err != nil {
+		t.Errorf("TestInjectShutdownContext: should not have error: %v", err)
+	}
+	if code := <-codes; code != http.StatusServiceUnavailable {
+		t.Errorf("TestInjectShutdownContext: handler should see the drain, got %d", code)
+	}
+}
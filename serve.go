@@ -0,0 +1,67 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ServeGracefully serves handler on addr until the daemon receives SIGTERM, SIGINT,
+// SIGQUIT or SIGHUP, then drains it within timeout and runs hooks, returning once
+// shutdown is complete. It builds and wires the Watcher and server itself, so it is
+// the one call needed by a daemon that just wants to shut down gracefully. The error
+// is nil after a clean shutdown; otherwise it is the error from serving or from
+// `OnStop`. Daemons that need more control should use `NewManagedServer`.
+//
+// Example use:
+//
+//	mux := http.NewServeMux()
+//	mux.HandleFunc("/", index)
+//	log.Fatal(httpdshutdown.ServeGracefully(":8080", mux, 5*time.Second, closeDB))
+func ServeGracefully(addr string, handler http.Handler, timeout time.Duration, hooks ...ShutdownHook) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, shutdownSignals...)
+	defer signal.Stop(sigs)
+	return serveGracefully(ln, sigs, handler, timeout, hooks)
+}
+
+// serveGracefully is the testable core of `ServeGracefully`, serving on ln and
+// shutting down on the first terminating signal from sigs.
+func serveGracefully(ln net.Listener, sigs <-chan os.Signal, handler http.Handler, timeout time.Duration, hooks []ShutdownHook) error {
+	w, err := NewWatcherDuration(timeout, hooks...)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	srv := NewManagedServer(ln.Addr().String(), handler, w)
+	w.Accepting(true)
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ln)
+	}()
+	for {
+		select {
+		case err := <-served:
+			// Serving failed before any signal, so there is nothing to drain.
+			_ = w.Close()
+			return err
+		case sig := <-sigs:
+			if !isTerminating(sig) && sig != syscall.SIGINT {
+				continue
+			}
+			stopErr := w.OnStop()
+			if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return stopErr
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package httpdshutdown
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServeGracefully(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TestServeGracefully: should listen, got %v", err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		rw.WriteHeader(http.StatusOK)
+	})
+	hookRan := false
+	hook := func() error {
+		hookRan = true
+		return nil
+	}
+	sigs := make(chan os.Signal, 1)
+	result := make(chan error, 1)
+	go func() {
+		result <- serveGracefully(ln, sigs, mux, 3*time.Second, []ShutdownHook{hook})
+	}()
+
+	codes := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			codes <- 0
+			return
+		}
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}()
+	<-started
+	sigs <- syscall.SIGUSR1 // not a terminating signal, so ignored
+	sigs <- syscall.SIGTERM
+	select {
+	case <-result:
+		t.Fatalf("TestServeGracefully: should wait for the in-flight request")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if code := <-codes; code != http.StatusOK {
+		t.Errorf("TestServeGracefully: in-flight request should complete, got %d", code)
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("TestServeGracefully: should shut down cleanly, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("TestServeGracefully: should return once shutdown completes")
+	}
+	if !hookRan {
+		t.Errorf("TestServeGracefully: hook should run")
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Errorf("TestServeGracefully: listener should be closed")
+	}
+}
+
+func TestServeGracefullyBadArgs(t *testing.T) {
+	if ServeGracefully("127.0.0.1:-1", http.NewServeMux(), time.Second) == nil {
+		t.Errorf("TestServeGracefullyBadArgs: should have error for a bad address")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TestServeGracefullyBadArgs: should listen, got %v", err)
+	}
+	if serveGracefully(ln, nil, http.NewServeMux(), 0, nil) == nil {
+		t.Errorf("TestServeGracefullyBadArgs: should have error for a zero timeout")
+	}
+}
@@ -0,0 +1,139 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// ManageServer hands srv's shutdown to the Watcher. When `OnStop` begins it calls
+// `srv.Shutdown`, which closes the server's listeners, and before any hooks run it
+// waits for that to complete, force-closing the server with `srv.Close` if the grace
+// period runs out first. Hooks can therefore assume the server is fully stopped.
+//
+// This is the correct way to drain an HTTP/2 server. A single HTTP/2 connection
+// carries many requests, so counting connections cannot stop new requests arriving
+// on it; `srv.Shutdown` sends GOAWAY on each connection, so clients stop opening
+// new streams while the ones in flight complete.
+func (w *Watcher) ManageServer(srv *http.Server) error {
+	if w == nil {
+		return errors.New("ManageServer: receiver is nil")
+	}
+	if srv == nil {
+		return errors.New("ManageServer: server is nil")
+	}
+	w.mu.Lock()
+	w.servers = append(w.servers, srv)
+	w.mu.Unlock()
+	return nil
+}
+
+// GracefulStopper is a server that can stop gracefully, such as a gRPC server. If it
+// also has a `Stop()` method, that is used to force it to stop.
+type GracefulStopper interface {
+	GracefulStop()
+}
+
+// ManageGracefulStopper hands s's shutdown to the Watcher, as `ManageServer` does for
+// an `http.Server`. When `OnStop` begins it calls `s.GracefulStop`, and before any
+// hooks run it waits for that to return. If the grace period runs out first, s is
+// force-stopped with its `Stop` method if it has one, and otherwise abandoned.
+//
+// Example use:
+//
+//	grpcServer := grpc.NewServer()
+//	err := watcher.ManageGracefulStopper(grpcServer)
+func (w *Watcher) ManageGracefulStopper(s GracefulStopper) error {
+	if w == nil {
+		return errors.New("ManageGracefulStopper: receiver is nil")
+	}
+	if s == nil {
+		return errors.New("ManageGracefulStopper: stopper is nil")
+	}
+	w.mu.Lock()
+	w.stoppers = append(w.stoppers, s)
+	w.mu.Unlock()
+	return nil
+}
+
+// NewManagedServer returns an `http.Server` for addr that is fully wired to w: its
+// `ConnState` and `ConnContext` feed the Watcher, its handler is wrapped with
+// `TrackHandler`, and it is registered with `ManageServer` so `OnStop` shuts it
+// down. The caller only needs to start it and arrange for `OnStop` to be called.
+//
+// Example use:
+//
+//	srv := httpdshutdown.NewManagedServer(":8080", mux, watcher)
+//	go watcher.RunUntilSignal()
+//	err := srv.ListenAndServe()
+func NewManagedServer(addr string, handler http.Handler, w *Watcher) *http.Server {
+	if w == nil {
+		panic("NewManagedServer: Watcher is nil")
+	}
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	srv := &http.Server{
+		Addr:        addr,
+		Handler:     w.TrackHandler(handler),
+		ConnState:   w.RecordConnStateConn,
+		ConnContext: w.ConnContext,
+	}
+	_ = w.ManageServer(srv) // only fails for nil arguments
+	return srv
+}
+
+// shutdownServers starts shutting down the managed servers and graceful stoppers.
+// The returned channel is closed once they have all stopped.
+func (w *Watcher) shutdownServers(ctx context.Context) <-chan struct{} {
+	w.mu.Lock()
+	servers := make([]*http.Server, len(w.servers))
+	copy(servers, w.servers)
+	stoppers := make([]GracefulStopper, len(w.stoppers))
+	copy(stoppers, w.stoppers)
+	w.mu.Unlock()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		var wg sync.WaitGroup
+		for _, s := range stoppers {
+			wg.Add(1)
+			go func(s GracefulStopper) {
+				defer wg.Done()
+				gracefulStop(ctx, s)
+			}(s)
+		}
+		for _, srv := range servers {
+			// Concurrently, so every server stops accepting at once and each
+			// gets the whole grace period to drain.
+			wg.Add(1)
+			go func(srv *http.Server) {
+				defer wg.Done()
+				if err := srv.Shutdown(ctx); err != nil {
+					// Shutdown was interrupted, so don't leave the server half open.
+					_ = srv.Close()
+				}
+			}(srv)
+		}
+		wg.Wait()
+	}()
+	return stopped
+}
+
+// gracefulStop stops s gracefully, or forcefully once ctx is done.
+func gracefulStop(ctx context.Context, s GracefulStopper) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.GracefulStop()
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if stopper, ok := s.(interface{ Stop() }); ok {
+			stopper.Stop()
+			<-done
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestManageServer(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestManageServer: should not be nil")
+	}
+	started := make(chan struct{})
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprintln(rw, "Hello, client")
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(handler))
+	ts.Config.ConnState = func(conn net.Conn, newState http.ConnState) {
+		w.RecordConnState(newState)
+	}
+	ts.Start()
+	defer ts.Close()
+	err := w.ManageServer(ts.Config)
+	if err != nil {
+		t.Fatalf("TestManageServer: should not have error")
+	}
+
+	addr := ts.Listener.Addr().String()
+	dialErr := make(chan error, 1)
+	_ = w.AddContextHook(func(ctx context.Context) error {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+		}
+		dialErr <- err
+		return nil
+	})
+
+	respErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(ts.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		respErr <- err
+	}()
+	// Wait for the request itself, as Shutdown may close a conn that has not sent one.
+	<-started
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestManageServer: should not have error: %v", err)
+	}
+	if <-dialErr == nil {
+		t.Errorf("TestManageServer: server should not accept connections when hooks run")
+	}
+	if err := <-respErr; err != nil {
+		t.Errorf("TestManageServer: in-flight request should complete: %v", err)
+	}
+}
+
+func TestManageServerConcurrent(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestManageServerConcurrent: should not be nil")
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.NotFoundHandler())
+	defer fast.Close()
+	_ = w.ManageServer(slow.Config)
+	_ = w.ManageServer(fast.Config)
+
+	go func() {
+		resp, err := http.Get(slow.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	stopErr := make(chan error, 1)
+	go func() {
+		stopErr <- w.OnStop()
+	}()
+	// The second server must stop accepting while the first is still draining.
+	addr := fast.Listener.Addr().String()
+	deadline := time.Now().Add(time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Errorf("TestManageServerConcurrent: second server should stop accepting while the first drains")
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	if err := <-stopErr; err != nil {
+		t.Errorf("TestManageServerConcurrent: should not have error: %v", err)
+	}
+}
+
+func TestManageServerNil(t *testing.T) {
+	w, _ := NewWatcher(3000)
+	if w.ManageServer(nil) == nil {
+		t.Errorf("TestManageServerNil: should have error")
+	}
+}
+
+func TestHardDeadline(t *testing.T) {
+	w, wErr := NewWatcher(10000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestHardDeadline: should not be nil")
+	}
+	hookRan := false
+	_ = w.AddNamedHook("cleanup", func() error {
+		hookRan = true
+		return nil
+	})
+	err := w.Configure(WithHardDeadline(100 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("TestHardDeadline: should not have error")
+	}
+	hang := make(chan struct{})
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	ts.Config.ConnState = func(conn net.Conn, newState http.ConnState) {
+		w.RecordConnState(newState)
+	}
+	ts.Start()
+	defer ts.Close()
+	// Deferred after Close, which waits for the handler to return.
+	defer close(hang)
+	_ = w.ManageServer(ts.Config)
+
+	respErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(ts.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		respErr <- err
+	}()
+	for w.ActiveConns() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	start := time.Now()
+	err = w.OnStop()
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("TestHardDeadline: should return at the hard deadline, took %v", elapsed)
+	}
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) || !shutdownErr.HardDeadline || !shutdownErr.TimedOut {
+		t.Errorf("TestHardDeadline: should report the hard deadline, got %v", err)
+	}
+	if hookRan {
+		t.Errorf("TestHardDeadline: hooks should be skipped")
+	}
+	if <-respErr == nil {
+		t.Errorf("TestHardDeadline: hanging connection should be force-closed")
+	}
+}
+
+func TestNewManagedServer(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestNewManagedServer: should not be nil")
+	}
+	w.Accepting(true)
+	release := make(chan struct{})
+	srv := NewManagedServer("127.0.0.1:0", http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-release
+	}), w)
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		t.Fatalf("TestNewManagedServer: %v", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+
+	respErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+		respErr <- err
+	}()
+	for w.InFlightRequests() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if w.ActiveConns() != 1 || len(w.LeakedConns()) != 1 {
+		t.Errorf("TestNewManagedServer: conn states should flow into the Watcher")
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestNewManagedServer: should not have error: %v", err)
+	}
+	if err := <-respErr; err != nil {
+		t.Errorf("TestNewManagedServer: in-flight request should complete: %v", err)
+	}
+	if err := <-serveErr; err != http.ErrServerClosed {
+		t.Errorf("TestNewManagedServer: server should be shut down, got %v", err)
+	}
+}
+
+// fakeStopper is a server whose GracefulStop blocks until it is released.
+type fakeStopper struct {
+	release  chan struct{}
+	graceful chan struct{} // Closed when GracefulStop is called.
+	stopped  bool
+}
+
+func newFakeStopper() *fakeStopper {
+	return &fakeStopper{release: make(chan struct{}), graceful: make(chan struct{})}
+}
+
+func (s *fakeStopper) GracefulStop() {
+	close(s.graceful)
+	<-s.release
+}
+
+// stoppableFakeStopper adds a Stop method that releases GracefulStop.
+type stoppableFakeStopper struct {
+	*fakeStopper
+}
+
+func (s stoppableFakeStopper) Stop() {
+	s.stopped = true
+	close(s.release)
+}
+
+func TestManageGracefulStopper(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestManageGracefulStopper: should not be nil")
+	}
+	s := newFakeStopper()
+	err := w.ManageGracefulStopper(s)
+	if err != nil {
+		t.Fatalf("TestManageGracefulStopper: should not have error")
+	}
+	if w.ManageGracefulStopper(nil) == nil {
+		t.Errorf("TestManageGracefulStopper: should reject a nil stopper")
+	}
+	_ = w.AddNamedHook("check", func() error {
+		select {
+		case <-s.release:
+		default:
+			t.Errorf("TestManageGracefulStopper: hooks should run after GracefulStop returns")
+		}
+		return nil
+	})
+	go func() {
+		<-s.graceful
+		time.Sleep(50 * time.Millisecond)
+		close(s.release)
+	}()
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestManageGracefulStopper: should not have error: %v", err)
+	}
+}
+
+func TestManageGracefulStopperTimeout(t *testing.T) {
+	w, wErr := NewWatcher(100)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestManageGracefulStopperTimeout: should not be nil")
+	}
+	s := stoppableFakeStopper{newFakeStopper()}
+	_ = w.ManageGracefulStopper(s)
+	err := w.OnStop()
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) || !shutdownErr.TimedOut {
+		t.Errorf("TestManageGracefulStopperTimeout: should time out, got %v", err)
+	}
+	if !s.stopped {
+		t.Errorf("TestManageGracefulStopperTimeout: should fall back to Stop")
+	}
+}
+
+func TestManageServerHTTP2(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestManageServerHTTP2: should not be nil")
+	}
+	release := make(chan struct{})
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+	}
+	ts := httptest.NewUnstartedServer(w.TrackHandler(http.HandlerFunc(handler)))
+	ts.EnableHTTP2 = true
+	ts.Config.ConnState = func(conn net.Conn, newState http.ConnState) {
+		w.RecordConnState(newState)
+	}
+	ts.StartTLS()
+	defer ts.Close()
+	_ = w.ManageServer(ts.Config)
+	client := ts.Client()
+	// Establish the connection first so later requests reuse it.
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("TestManageServerHTTP2: %v", err)
+	}
+	resp.Body.Close()
+
+	slowProto := make(chan string, 1)
+	go func() {
+		resp, err := client.Get(ts.URL + "/slow")
+		if err != nil {
+			slowProto <- err.Error()
+			return
+		}
+		resp.Body.Close()
+		slowProto <- resp.Proto
+	}()
+	for w.InFlightRequests() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	stopErr := make(chan error, 1)
+	go func() {
+		stopErr <- w.OnStop()
+	}()
+
+	// Once GOAWAY arrives the client stops using the connection, and the listener
+	// is closed, so new requests fail.
+	refused := false
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			refused = true
+			break
+		}
+		resp.Body.Close()
+	}
+	if !refused {
+		t.Errorf("TestManageServerHTTP2: new streams should be refused during the drain")
+	}
+	close(release)
+	if proto := <-slowProto; proto != "HTTP/2.0" {
+		t.Errorf("TestManageServerHTTP2: in-flight stream should complete over HTTP/2, got %s", proto)
+	}
+	if err := <-stopErr; err != nil {
+		t.Errorf("TestManageServerHTTP2: should not have error: %v", err)
+	}
+}
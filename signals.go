@@ -0,0 +1,234 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// isTerminating reports whether `SigHandle` treats sig as a request to shut down.
+func isTerminating(sig os.Signal) bool {
+	return sig == syscall.SIGTERM || sig == syscall.SIGQUIT || sig == syscall.SIGHUP
+}
+
+// shutdownSignals are the signals `SigHandle` shuts the daemon down on.
+var shutdownSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP}
+
+// notifySignals returns the signals `RunUntilSignal` subscribes to: those that shut
+// the daemon down, plus any registered with `OnSignal` or `WithIgnoredSignals`.
+// Subscribing to every signal would also catch ones such as SIGURG, which the runtime
+// uses internally, and SIGTSTP, which would stop job control from working.
+func (w *Watcher) notifySignals() []os.Signal {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sigs := append([]os.Signal(nil), shutdownSignals...)
+	for sig := range w.sigHandlers {
+		sigs = append(sigs, sig)
+	}
+	for sig := range w.ignoredSignals {
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
+// OnSignal registers f to be called by `SigHandle` when sig is received, such as
+// reopening log files on SIGUSR1. The daemon keeps running afterwards. Signals that
+// `SigHandle` uses to shut down or exit cannot be registered.
+//
+// Example use:
+//
+//	err := watcher.OnSignal(syscall.SIGUSR1, reopenLogs)
+func (w *Watcher) OnSignal(sig os.Signal, f func() error) error {
+	if w == nil {
+		return errors.New("OnSignal: receiver is nil")
+	}
+	if f == nil {
+		return errors.New("OnSignal: handler is nil")
+	}
+	if isTerminating(sig) || sig == syscall.SIGINT {
+		return errors.New("OnSignal: " + sig.String() + " is handled by SigHandle")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.sigHandlers == nil {
+		w.sigHandlers = make(map[os.Signal]func() error)
+	}
+	w.sigHandlers[sig] = f
+	return nil
+}
+
+// sigHandler returns the handler registered for sig, if any.
+func (w *Watcher) sigHandler(sig os.Signal) func() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sigHandlers[sig]
+}
+
+// WithInterruptGraceful makes `SigHandle` shut down gracefully on SIGINT, running
+// hooks as it would for SIGTERM, instead of panicking. This is friendlier for
+// Ctrl-C during development.
+func WithInterruptGraceful(graceful bool) Option {
+	return func(w *Watcher) error {
+		w.gracefulInterrupt = graceful
+		return nil
+	}
+}
+
+func (w *Watcher) interruptGraceful() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.gracefulInterrupt
+}
+
+// WithIgnoredSignals makes `SigHandle` drop the given signals before deciding what
+// to do with them, so signals such as SIGPIPE or SIGCHLD that some environments
+// deliver routinely are neither acted on nor logged. An ignored terminating signal no
+// longer shuts the daemon down.
+//
+// Example use:
+//
+//	err := watcher.Configure(httpdshutdown.WithIgnoredSignals(syscall.SIGPIPE, syscall.SIGCHLD))
+func WithIgnoredSignals(sigs ...os.Signal) Option {
+	return func(w *Watcher) error {
+		ignored := make(map[os.Signal]struct{}, len(sigs))
+		for _, sig := range sigs {
+			ignored[sig] = struct{}{}
+		}
+		w.ignoredSignals = ignored
+		return nil
+	}
+}
+
+// signalIgnored reports whether sig was passed to `WithIgnoredSignals`.
+func (w *Watcher) signalIgnored(sig os.Signal) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.ignoredSignals[sig]
+	return ok
+}
+
+// WithTwoPhaseShutdown makes `SigHandle` split shutdown across two terminating
+// signals. The first stops accepting and flips `ReadinessHandler` to 503 so load
+// balancers drain the daemon; the second runs `OnStop` and exits.
+func WithTwoPhaseShutdown(twoPhase bool) Option {
+	return func(w *Watcher) error {
+		w.twoPhase = twoPhase
+		return nil
+	}
+}
+
+// WithSignalExitCodes sets the exit code `SigHandle` reports after a graceful
+// shutdown triggered by each signal in codes, such as 128 plus the signal number as
+// shells do. Signals without a code report 0, and a shutdown that fails still
+// reports the code from `ExitCode`.
+//
+// Example use:
+//
+//	err := watcher.Configure(httpdshutdown.WithSignalExitCodes(map[os.Signal]int{
+//	        syscall.SIGTERM: 128 + int(syscall.SIGTERM),
+//	}))
+func WithSignalExitCodes(codes map[os.Signal]int) Option {
+	return func(w *Watcher) error {
+		exitCodes := make(map[os.Signal]int, len(codes))
+		for sig, code := range codes {
+			exitCodes[sig] = code
+		}
+		w.exitCodes = exitCodes
+		return nil
+	}
+}
+
+// WithFailureExitCode sets the exit code reported for a shutdown that fails, such as
+// by timing out or with a hook error, in place of the default of 1. It is used by
+// `ExitCode`, and so by `SigHandle` and `OnStopAndExit`.
+func WithFailureExitCode(code int) Option {
+	return func(w *Watcher) error {
+		if code < 1 || code > 255 {
+			return errors.New("WithFailureExitCode: code must be between 1 and 255")
+		}
+		w.failureCode = code
+		return nil
+	}
+}
+
+// ExitCode returns the exit code a daemon should use after `OnStop` returned err: 0
+// if err is nil, otherwise the code set with `WithFailureExitCode`, which defaults
+// to 1.
+func (w *Watcher) ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if w == nil {
+		return 1
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failureCode == 0 {
+		return 1
+	}
+	return w.failureCode
+}
+
+// OnStopAndExit runs `OnStop` and then exits the process with the code from
+// `ExitCode`, for daemons that drive shutdown themselves and have no use for the
+// exit code channel of `SigHandle`.
+//
+// Example use:
+//
+//	<-ctx.Done()
+//	watcher.OnStopAndExit()
+func (w *Watcher) OnStopAndExit() {
+	os.Exit(w.ExitCode(w.OnStop()))
+}
+
+// exitCode returns the exit code `SigHandle` reports after a graceful shutdown
+// triggered by sig.
+func (w *Watcher) exitCode(sig os.Signal) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.exitCodes[sig]
+}
+
+// beginTwoPhaseShutdown performs the first phase of a two-phase shutdown, returning
+// false if two-phase mode is off or the first phase has already happened.
+func (w *Watcher) beginTwoPhaseShutdown() bool {
+	w.mu.Lock()
+	defer w.unlockAndNotify()
+	if !w.twoPhase || w.shuttingDown {
+		return false
+	}
+	w.setAcceptingLocked(false)
+	w.shuttingDown = true
+	w.updateFastPathLocked()
+	return true
+}
+
+// RunUntilSignal installs a signal handler, blocks until `SigHandle` decides the
+// daemon should exit, and then exits the process with the resulting code. It replaces
+// the goroutine boilerplate shown for `SigHandle`, so it is typically launched as a
+// goroutine before the daemon starts serving. It only subscribes to the signals that
+// shut the daemon down and those registered with `OnSignal` or `WithIgnoredSignals`
+// before it is called.
+//
+// Example use:
+//
+//	go watcher.RunUntilSignal()
+//	log.Fatal(srv.ListenAndServe())
+func (w *Watcher) RunUntilSignal() {
+	if w == nil {
+		// panic since this will typically be launched as a goroutine.
+		panic("RunUntilSignal: Watcher is nil")
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, w.notifySignals()...)
+	os.Exit(w.waitForExitCode(sigs))
+}
+
+// waitForExitCode runs `SigHandle` on sigs and returns the first exit code it
+// produces. This is the testable core of `RunUntilSignal`.
+func (w *Watcher) waitForExitCode(sigs <-chan os.Signal) int {
+	exitcode := make(chan int, 1)
+	go w.SigHandle(sigs, exitcode)
+	return <-exitcode
+}
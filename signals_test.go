@@ -0,0 +1,315 @@
+package httpdshutdown
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOnSignal(t *testing.T) {
+	hookRan := make(chan bool, 1)
+	w, wErr := NewWatcher(1000, func() error {
+		hookRan <- true
+		return nil
+	})
+	if w == nil || wErr != nil {
+		t.Fatalf("TestOnSignal: should not be nil")
+	}
+	usr1 := make(chan bool, 1)
+	err := w.OnSignal(syscall.SIGUSR1, func() error {
+		usr1 <- true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("TestOnSignal: should not have error")
+	}
+
+	sigs := make(chan os.Signal, 1)
+	exitcode := make(chan int, 1)
+	go w.SigHandle(sigs, exitcode)
+	defer close(sigs)
+	sigs <- syscall.SIGUSR1
+
+	select {
+	case <-usr1:
+	case <-time.After(time.Second):
+		t.Fatalf("TestOnSignal: SIGUSR1 handler should have run")
+	}
+	select {
+	case code := <-exitcode:
+		t.Errorf("TestOnSignal: should not exit, got code %d", code)
+	case <-hookRan:
+		t.Errorf("TestOnSignal: shutdown hooks should not run")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if w.IsShuttingDown() {
+		t.Errorf("TestOnSignal: should not be shutting down")
+	}
+}
+
+func TestOnSignalTerminating(t *testing.T) {
+	w, _ := NewWatcher(1000)
+	for _, sig := range []os.Signal{syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP, syscall.SIGINT} {
+		err := w.OnSignal(sig, func() error { return nil })
+		if err == nil {
+			t.Errorf("TestOnSignalTerminating: should not register %v", sig)
+		}
+	}
+	if w.OnSignal(syscall.SIGUSR2, nil) == nil {
+		t.Errorf("TestOnSignalTerminating: should not register nil handler")
+	}
+}
+
+func TestInterruptGraceful(t *testing.T) {
+	hookRan := make(chan bool, 1)
+	w, wErr := NewWatcher(1000, func() error {
+		hookRan <- true
+		return nil
+	})
+	if w == nil || wErr != nil {
+		t.Fatalf("TestInterruptGraceful: should not be nil")
+	}
+	err := w.Configure(WithInterruptGraceful(true))
+	if err != nil {
+		t.Fatalf("TestInterruptGraceful: should not have error")
+	}
+	sigs := make(chan os.Signal, 1)
+	exitcode := make(chan int, 1)
+	go w.SigHandle(sigs, exitcode)
+	defer close(sigs)
+	sigs <- syscall.SIGINT
+
+	select {
+	case code := <-exitcode:
+		if code != 0 {
+			t.Errorf("TestInterruptGraceful: should exit cleanly, got code %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestInterruptGraceful: should have exited")
+	}
+	select {
+	case <-hookRan:
+	default:
+		t.Errorf("TestInterruptGraceful: hooks should have run")
+	}
+}
+
+type testContextKey string
+
+func TestSigHandleContext(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestSigHandleContext: should not be nil")
+	}
+	key := testContextKey("trace")
+	seen := make(chan interface{}, 1)
+	_ = w.AddContextHook(func(ctx context.Context) error {
+		seen <- ctx.Value(key)
+		return nil
+	})
+	ctx := context.WithValue(context.Background(), key, "span-1")
+	sigs := make(chan os.Signal, 1)
+	exitcode := make(chan int, 1)
+	go w.SigHandleContext(ctx, sigs, exitcode)
+	defer close(sigs)
+	sigs <- syscall.SIGTERM
+
+	select {
+	case code := <-exitcode:
+		if code != 0 {
+			t.Errorf("TestSigHandleContext: should exit cleanly, got code %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestSigHandleContext: should have exited")
+	}
+	if v := <-seen; v != "span-1" {
+		t.Errorf("TestSigHandleContext: hook should see the context value, got %v", v)
+	}
+}
+
+func TestTwoPhaseShutdown(t *testing.T) {
+	hookRan := make(chan bool, 1)
+	w, wErr := NewWatcher(1000, func() error {
+		hookRan <- true
+		return nil
+	})
+	if w == nil || wErr != nil {
+		t.Fatalf("TestTwoPhaseShutdown: should not be nil")
+	}
+	err := w.Configure(WithTwoPhaseShutdown(true))
+	if err != nil {
+		t.Fatalf("TestTwoPhaseShutdown: should not have error")
+	}
+	w.Accepting(true)
+	sigs := make(chan os.Signal)
+	exitcode := make(chan int, 1)
+	go w.SigHandle(sigs, exitcode)
+	defer close(sigs)
+
+	sigs <- syscall.SIGTERM
+	sigs <- syscall.SIGUSR1 // unbuffered, so the first signal has been handled
+	if w.IsAccepting() || !w.IsShuttingDown() {
+		t.Errorf("TestTwoPhaseShutdown: first signal should stop accepting")
+	}
+	if code := readinessCode(w); code != http.StatusServiceUnavailable {
+		t.Errorf("TestTwoPhaseShutdown: should not be ready after first signal, got %d", code)
+	}
+	select {
+	case code := <-exitcode:
+		t.Fatalf("TestTwoPhaseShutdown: first signal should not exit, got code %d", code)
+	case <-hookRan:
+		t.Fatalf("TestTwoPhaseShutdown: first signal should not run hooks")
+	default:
+	}
+
+	sigs <- syscall.SIGTERM
+	select {
+	case code := <-exitcode:
+		if code != 0 {
+			t.Errorf("TestTwoPhaseShutdown: should exit cleanly, got code %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("TestTwoPhaseShutdown: second signal should exit")
+	}
+	select {
+	case <-hookRan:
+	default:
+		t.Errorf("TestTwoPhaseShutdown: hooks should have run")
+	}
+}
+
+func TestWaitForExitCode(t *testing.T) {
+	w, _ := NewWatcher(1000, sampleShutdownHook)
+	sigs := make(chan os.Signal, 1)
+	defer close(sigs)
+	sigs <- syscall.SIGTERM
+	if code := w.waitForExitCode(sigs); code != 0 {
+		t.Errorf("TestWaitForExitCode: should exit cleanly, got code %d", code)
+	}
+
+	w, _ = NewWatcher(1000, failingShutdownHook)
+	failSigs := make(chan os.Signal, 1)
+	defer close(failSigs)
+	failSigs <- syscall.SIGQUIT
+	if code := w.waitForExitCode(failSigs); code != 1 {
+		t.Errorf("TestWaitForExitCode: failing hook should exit with 1, got code %d", code)
+	}
+}
+
+func TestSignalExitCodes(t *testing.T) {
+	for sig, want := range map[os.Signal]int{
+		syscall.SIGTERM: 128 + int(syscall.SIGTERM),
+		syscall.SIGHUP:  128 + int(syscall.SIGHUP),
+		syscall.SIGQUIT: 0,
+	} {
+		w, wErr := NewWatcher(1000)
+		if w == nil || wErr != nil {
+			t.Fatalf("TestSignalExitCodes: should not be nil")
+		}
+		err := w.Configure(WithSignalExitCodes(map[os.Signal]int{
+			syscall.SIGTERM: 128 + int(syscall.SIGTERM),
+			syscall.SIGHUP:  128 + int(syscall.SIGHUP),
+		}))
+		if err != nil {
+			t.Fatalf("TestSignalExitCodes: should not have error")
+		}
+		sigs := make(chan os.Signal, 1)
+		sigs <- sig
+		if got := w.waitForExitCode(sigs); got != want {
+			t.Errorf("TestSignalExitCodes: %v should exit with %d, got %d", sig, want, got)
+		}
+		close(sigs)
+	}
+}
+
+func TestIgnoredSignals(t *testing.T) {
+	w, _, buf := newLoggedWatcher(t, 1000)
+	err := w.Configure(WithIgnoredSignals(syscall.SIGPIPE, syscall.SIGCHLD))
+	if err != nil {
+		t.Fatalf("TestIgnoredSignals: should not have error")
+	}
+	w.Accepting(true)
+	sigs := make(chan os.Signal, 2)
+	exitcode := make(chan int, 1)
+	sigs <- syscall.SIGPIPE
+	sigs <- syscall.SIGCHLD
+	close(sigs)
+	w.SigHandle(sigs, exitcode)
+	if len(exitcode) != 0 || w.IsShuttingDown() || !w.IsAccepting() {
+		t.Errorf("TestIgnoredSignals: ignored signals should not cause any action")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("TestIgnoredSignals: ignored signals should not be logged, got %q", buf.String())
+	}
+
+	sigs = make(chan os.Signal, 1)
+	sigs <- syscall.SIGUSR2
+	close(sigs)
+	w.SigHandle(sigs, exitcode)
+	if !strings.Contains(buf.String(), "unchecked signal") {
+		t.Errorf("TestIgnoredSignals: other signals should still be logged, got %q", buf.String())
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	w, wErr := NewWatcher(1000, sampleShutdownHook)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestExitCode: should not be nil")
+	}
+	if code := w.ExitCode(w.OnStop()); code != 0 {
+		t.Errorf("TestExitCode: success should exit with 0, got %d", code)
+	}
+
+	w, _ = NewWatcher(50)
+	w.RecordConn(true) // never closed
+	if code := w.ExitCode(w.OnStop()); code != 1 {
+		t.Errorf("TestExitCode: timeout should exit with 1, got %d", code)
+	}
+
+	w, _ = NewWatcher(1000, failingShutdownHook)
+	if err := w.Configure(WithFailureExitCode(3)); err != nil {
+		t.Fatalf("TestExitCode: should configure, got %v", err)
+	}
+	if code := w.ExitCode(w.OnStop()); code != 3 {
+		t.Errorf("TestExitCode: hook error should exit with the configured code, got %d", code)
+	}
+	failSigs := make(chan os.Signal, 1)
+	defer close(failSigs)
+	failSigs <- syscall.SIGTERM
+	w, _ = NewWatcher(1000, failingShutdownHook)
+	_ = w.Configure(WithFailureExitCode(3))
+	if code := w.waitForExitCode(failSigs); code != 3 {
+		t.Errorf("TestExitCode: SigHandle should report the configured code, got %d", code)
+	}
+
+	if w.Configure(WithFailureExitCode(0)) == nil {
+		t.Errorf("TestExitCode: should have error for a zero failure code")
+	}
+	if w.Configure(WithFailureExitCode(256)) == nil {
+		t.Errorf("TestExitCode: should have error for a failure code above 255")
+	}
+}
+
+func TestNotifySignals(t *testing.T) {
+	w, _ := NewWatcher(1000)
+	_ = w.OnSignal(syscall.SIGUSR1, func() error { return nil })
+	_ = w.Configure(WithIgnoredSignals(syscall.SIGPIPE))
+	got := make(map[os.Signal]bool)
+	for _, sig := range w.notifySignals() {
+		got[sig] = true
+	}
+	for _, sig := range []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGPIPE} {
+		if !got[sig] {
+			t.Errorf("TestNotifySignals: should subscribe to %v", sig)
+		}
+	}
+	for _, sig := range []os.Signal{syscall.SIGURG, syscall.SIGTSTP, syscall.SIGCHLD} {
+		if got[sig] {
+			t.Errorf("TestNotifySignals: should not subscribe to %v", sig)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package httpdshutdown
+
+import "time"
+
+// WatcherStats is a snapshot of the Watcher's state returned by `Stats`.
+type WatcherStats struct {
+	ActiveConns   int64         `json:"active_conns"`
+	PeakConns     int64         `json:"peak_conns"`
+	RejectedConns int64         `json:"rejected_conns"`
+	Phase         Phase         `json:"phase"`
+	Accepting     bool          `json:"accepting"`
+	ShuttingDown  bool          `json:"shutting_down"`
+	LastDrain     time.Duration `json:"last_drain"`
+}
+
+// Stats returns a snapshot of the Watcher's counters and lifecycle state, taken
+// under a single lock. Unlike calling `CurrentPhase`, `IsAccepting` and the other
+// getters one after another, the phase and flags are consistent with each other; for
+// instance, as `Accepting(true)` is ignored during a drain, a snapshot taken while an
+// uncancelled `OnStop` drains is never accepting. The connection counts are updated
+// without the lock while the Watcher is accepting, so they are only as current as
+// the moment they were read, though the peak always covers the active count.
+//
+// Example use:
+//
+//	stats := watcher.Stats()
+//	log.Printf("%v: %d conns open, %d at peak", stats.Phase, stats.ActiveConns, stats.PeakConns)
+func (w *Watcher) Stats() WatcherStats {
+	if w == nil {
+		return WatcherStats{}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	active := w.activeConns.Load()
+	// On the fast path a conn is counted before the peak is raised, so the peak may
+	// briefly lag behind the count it must cover.
+	peak := max(w.peakConns.Load(), active)
+	return WatcherStats{
+		ActiveConns:   active,
+		PeakConns:     peak,
+		RejectedConns: w.rejectedConns,
+		Phase:         w.currentPhaseLocked(),
+		Accepting:     w.accepting,
+		ShuttingDown:  w.shuttingDown,
+		LastDrain:     w.lastDrain,
+	}
+}
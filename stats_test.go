@@ -0,0 +1,93 @@
+package httpdshutdown
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestStats: should not be nil")
+	}
+	if got := w.Stats(); got != (WatcherStats{}) {
+		t.Errorf("TestStats: should start empty, got %+v", got)
+	}
+	w.Accepting(true)
+	w.RecordConn(true)
+	w.RecordConn(false)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				w.RecordConn(true)
+				w.RecordConn(false)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for accepting := false; ; accepting = !accepting {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			w.Accepting(accepting)
+		}
+	}()
+	for i := 0; i < 10000; i++ {
+		s := w.Stats()
+		if s.ActiveConns < 0 || s.PeakConns < s.ActiveConns {
+			t.Fatalf("TestStats: counts should be consistent, got %+v", s)
+		}
+		if s.Accepting && s.ShuttingDown {
+			t.Fatalf("TestStats: should not be accepting while shutting down, got %+v", s)
+		}
+		if (s.Phase == PhaseAccepting) != s.Accepting {
+			t.Fatalf("TestStats: phase should match the accepting flag, got %+v", s)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	w.Accepting(true)
+	w.RecordConn(true)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	for !w.IsShuttingDown() {
+		time.Sleep(time.Millisecond)
+	}
+	w.Accepting(true)
+	s := w.Stats()
+	if s.Accepting || !s.ShuttingDown || s.Phase != PhaseDraining || s.ActiveConns != 1 {
+		t.Errorf("TestStats: should be draining, not accepting, got %+v", s)
+	}
+	w.RecordConn(false)
+	if err := <-errChan; err != nil {
+		t.Fatalf("TestStats: should stop cleanly, got %v", err)
+	}
+	s = w.Stats()
+	if s.Phase != PhaseStopped || !s.ShuttingDown || s.Accepting || s.ActiveConns != 0 {
+		t.Errorf("TestStats: should be stopped, got %+v", s)
+	}
+	if s.PeakConns < 1 {
+		t.Errorf("TestStats: should have a peak, got %+v", s)
+	}
+	if s.LastDrain < 0 || s.LastDrain > time.Second {
+		t.Errorf("TestStats: should record the drain, got %+v", s)
+	}
+}
@@ -0,0 +1,142 @@
+package httpdshutdown
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func getStatus(t *testing.T, w *Watcher) Status {
+	rec := httptest.NewRecorder()
+	w.StatusHandler()(rec, httptest.NewRequest("GET", "/debug/shutdown", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("getStatus: unexpected code %d", rec.Code)
+	}
+	var s Status
+	err := json.NewDecoder(rec.Body).Decode(&s)
+	if err != nil {
+		t.Fatalf("getStatus: %v", err)
+	}
+	return s
+}
+
+func TestStatusHandler(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestStatusHandler: should not be nil")
+	}
+	s := getStatus(t, w)
+	if s.Accepting || s.ShuttingDown || s.ActiveConns != 0 || s.TimeoutMS != 1000 {
+		t.Errorf("TestStatusHandler: unexpected initial status %+v", s)
+	}
+
+	w.Accepting(true)
+	w.RecordConnState(http.StateNew)
+	w.RecordConnState(http.StateNew)
+	w.RecordConnState(http.StateClosed)
+	s = getStatus(t, w)
+	if !s.Accepting || s.ShuttingDown || s.ActiveConns != 1 {
+		t.Errorf("TestStatusHandler: unexpected serving status %+v", s)
+	}
+
+	w.RecordConnState(http.StateHijacked)
+	err := w.OnStop()
+	if err != nil {
+		t.Errorf("TestStatusHandler: should not have error")
+	}
+	s = getStatus(t, w)
+	if s.Accepting || !s.ShuttingDown || s.ActiveConns != 0 {
+		t.Errorf("TestStatusHandler: unexpected stopped status %+v", s)
+	}
+}
+
+func readinessCode(w *Watcher) int {
+	rec := httptest.NewRecorder()
+	w.ReadinessHandler()(rec, httptest.NewRequest("GET", "/readyz", nil))
+	return rec.Code
+}
+
+func TestReadinessHandler(t *testing.T) {
+	w, clock := newFakeClockWatcher(t, 3000)
+	w.SetReady(true)
+	if code := readinessCode(w); code != http.StatusOK {
+		t.Errorf("TestReadinessHandler: should be ready before shutdown, got %d", code)
+	}
+	w.RecordConn(true)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.OnStop()
+	}()
+	clock.BlockUntil(1)
+	if code := readinessCode(w); code != http.StatusServiceUnavailable {
+		t.Errorf("TestReadinessHandler: should not be ready during shutdown, got %d", code)
+	}
+	w.RecordConn(false)
+	<-errChan
+	if code := readinessCode(w); code != http.StatusServiceUnavailable {
+		t.Errorf("TestReadinessHandler: should not be ready after shutdown, got %d", code)
+	}
+}
+
+func TestReadinessWarmup(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestReadinessWarmup: should not be nil")
+	}
+	w.Accepting(true)
+	if code := readinessCode(w); code != http.StatusServiceUnavailable {
+		t.Errorf("TestReadinessWarmup: should not be ready during warmup, got %d", code)
+	}
+	w.SetReady(true)
+	if code := readinessCode(w); code != http.StatusOK {
+		t.Errorf("TestReadinessWarmup: should be ready, got %d", code)
+	}
+	if !getStatus(t, w).Ready {
+		t.Errorf("TestReadinessWarmup: status should report ready")
+	}
+	err := w.OnStop()
+	if err != nil {
+		t.Errorf("TestReadinessWarmup: should not have error")
+	}
+	if code := readinessCode(w); code != http.StatusServiceUnavailable {
+		t.Errorf("TestReadinessWarmup: should not be ready while draining, got %d", code)
+	}
+	if w.IsReady() || getStatus(t, w).Ready {
+		t.Errorf("TestReadinessWarmup: should not report ready while draining")
+	}
+}
+
+func TestWaitForReady(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestWaitForReady: should not be nil")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := w.WaitForReady(ctx); err != context.DeadlineExceeded {
+		t.Errorf("TestWaitForReady: should time out while not accepting, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		w.Accepting(true)
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.WaitForReady(ctx); err != nil || !w.IsAccepting() {
+		t.Errorf("TestWaitForReady: should unblock once accepting, got %v", err)
+	}
+	if err := w.WaitForReady(ctx); err != nil {
+		t.Errorf("TestWaitForReady: should return at once while accepting, got %v", err)
+	}
+
+	w.Accepting(false)
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := w.WaitForReady(ctx); err == nil {
+		t.Errorf("TestWaitForReady: should block again once accepting is turned off")
+	}
+}
@@ -0,0 +1,397 @@
+package httpdshutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// stopConfig holds the settings that vary between the OnStop variants.
+type stopConfig struct {
+	ctx       context.Context // Passed to hooks.
+	skipHooks bool            // Drain only, without running hooks.
+	timeout   time.Duration   // Overrides the Watcher's timeout when non-zero.
+	drainTime *time.Duration  // Set to how long the drain took, if non-nil.
+	cancel    <-chan struct{} // Aborts the drain as Cancel does once closed, if non-nil.
+}
+
+// gracePeriod returns how long the drain may take under cfg, or zero for no limit.
+func (w *Watcher) gracePeriod(cfg stopConfig) time.Duration {
+	if cfg.timeout != 0 {
+		return cfg.timeout
+	}
+	return w.timeout
+}
+
+// unwiredLocked reports whether the Watcher has never seen a connection, request or
+// worker, and has no hooks, servers or children, so `OnStop` has nothing to do.
+// w.mu must be held.
+func (w *Watcher) unwiredLocked() bool {
+	return w.peakConns.Load() == 0 && w.inFlight == 0 && w.workers == 0 &&
+		len(w.shutdownHooks) == 0 && len(w.preDrainHooks) == 0 &&
+		len(w.criticalHooks) == 0 && len(w.finalHooks) == 0 &&
+		len(w.servers) == 0 && len(w.stoppers) == 0 && len(w.listeners) == 0 &&
+		len(w.children) == 0
+}
+
+// drainState is what the phases of one `stop` share.
+type drainState struct {
+	cfg             stopConfig
+	coordinator     DrainCoordinator // Released once the shutdown is over, if non-nil.
+	clock           Clock
+	start           time.Time
+	startConns      int64
+	gracePeriod     time.Duration
+	cancelChan      chan struct{}    // Closed by Cancel.
+	closeChan       <-chan struct{}  // Closed by Close.
+	aborted         chan struct{}    // Closed once the drain is cancelled, to cancel the children.
+	stopDone        chan struct{}    // Closed once stop has returned.
+	finished        chan struct{}    // Closed as stop returns, ending its goroutines.
+	drained         <-chan struct{}  // Closed once conns, requests and workers drain.
+	graceExpired    chan struct{}    // Closed when the grace period runs out.
+	minElapsed      <-chan time.Time // Fires after WithMinDrainDuration, nil without it.
+	hardExpired     <-chan time.Time // Fires at WithHardDeadline, nil without it.
+	serversStopped  <-chan struct{}
+	stopServers     context.CancelFunc // Force-closes the managed servers.
+	childrenStopped <-chan []error
+}
+
+// stop implements `OnStop` and its variants. It runs the phases of a shutdown in
+// turn: the pre-drain hooks, the drain, stopping servers and children, the hooks and
+// the report.
+func (w *Watcher) stop(cfg stopConfig) error {
+	w.mu.Lock()
+	dryRun := w.dryRun
+	w.mu.Unlock()
+	if dryRun {
+		return w.dryRunStop(cfg)
+	}
+	w.mu.Lock()
+	closed, coordinator := w.closed, w.coordinator
+	if !closed {
+		w.stopping++
+	}
+	w.mu.Unlock()
+	if closed {
+		return errors.New("OnStop: watcher is closed")
+	}
+	defer func() {
+		// Deferred first so it runs last, after the cleanup that marks the Watcher
+		// done, which Reset must not race with.
+		w.mu.Lock()
+		w.stopping--
+		w.mu.Unlock()
+	}()
+	if coordinator != nil {
+		err := coordinator.AcquireDrainSlot(cfg.ctx)
+		if err != nil {
+			return fmt.Errorf("OnStop: could not acquire drain slot: %w", err)
+		}
+	}
+	var preResults []HookResult
+	if !cfg.skipHooks {
+		w.mu.Lock()
+		w.preDraining = true
+		w.mu.Unlock()
+		preResults = w.runPreDrainHooks(cfg.ctx)
+		w.mu.Lock()
+		w.preDraining = false
+		w.mu.Unlock()
+	}
+	d, err := w.beginDrain(cfg, coordinator)
+	if err != nil {
+		return err
+	}
+	defer w.endStop(d)
+	shutdownErr := w.waitForDrain(d)
+	w.finishDrain(d, shutdownErr)
+	results := w.runStopHooks(d, shutdownErr, preResults)
+	w.reportShutdown(d, shutdownErr, results)
+	if !shutdownErr.failed() {
+		return nil
+	}
+	return shutdownErr
+}
+
+// beginDrain stops accepting, marks the Watcher as shutting down and starts
+// everything the drain waits on: the drain itself, the timers, the managed servers
+// and the children. The returned state must be passed to `endStop`.
+func (w *Watcher) beginDrain(cfg stopConfig, coordinator DrainCoordinator) (*drainState, error) {
+	d := &drainState{
+		cfg:          cfg,
+		coordinator:  coordinator,
+		cancelChan:   make(chan struct{}),
+		stopDone:     make(chan struct{}),
+		finished:     make(chan struct{}),
+		graceExpired: make(chan struct{}),
+		aborted:      make(chan struct{}),
+	}
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		if coordinator != nil {
+			coordinator.ReleaseDrainSlot()
+		}
+		return nil, errors.New("OnStop: watcher is closed")
+	}
+	neverAccepted, unwired := !w.everAccepted, w.unwiredLocked()
+	w.setAcceptingLocked(false)
+	w.shuttingDown = true
+	w.updateFastPathLocked()
+	w.cancelled = false
+	w.cancelChan = d.cancelChan
+	w.stopDone = d.stopDone
+	d.closeChan = w.closedChanLocked()
+	d.clock = w.clock
+	d.start, d.startConns = d.clock.Now(), w.activeConns.Load()
+	d.gracePeriod = w.gracePeriod(cfg)
+	if d.gracePeriod > 0 {
+		w.graceDeadline = d.start.Add(d.gracePeriod)
+	}
+	threshold, minDrain, hardDeadline := w.drainThreshold, w.minDrain, w.hardDeadline
+	progressInterval, progressFn, progressJitter := w.progressInterval, w.progressFn, w.progressJitter
+	closeIdle := w.closeIdle
+	w.draining = true
+	w.sendDrainUpdateLocked()
+	w.checkQuiescedLocked()
+	w.unlockAndNotify()
+	w.notifySystemd("STOPPING=1")
+	if neverAccepted {
+		// With nothing ever served the drain finishes at once, which can hide a
+		// startup that never called Accepting(true).
+		w.logf("OnStop: warning: shutting down a Watcher that was never accepting")
+	}
+	if unwired {
+		w.logf("OnStop: warning: nothing to drain and no hooks, the Watcher may not be wired to anything")
+	}
+	w.closeListeners()
+	if closeIdle {
+		w.closeIdleConns()
+	}
+	d.drained = w.waitDrained(threshold, d.finished)
+	if progressFn != nil {
+		go w.reportProgress(d.clock, progressInterval, progressJitter, progressFn, d.finished)
+	}
+	// With NoTimeout graceExpired is never closed, so we only wait on conns.
+	if d.gracePeriod > 0 {
+		timeout := d.clock.After(d.gracePeriod)
+		go func() {
+			select {
+			case <-timeout:
+				close(d.graceExpired)
+			case <-d.finished:
+			}
+		}()
+	}
+	go func() {
+		select {
+		case <-d.cancelChan:
+		case <-d.closeChan:
+		case <-cfg.cancel:
+		case <-d.finished:
+			return
+		}
+		close(d.aborted)
+	}()
+	var serverCtx context.Context
+	serverCtx, d.stopServers = context.WithCancel(context.Background())
+	d.serversStopped = w.shutdownServers(serverCtx)
+	d.childrenStopped = w.stopChildren(cfg.ctx, d.gracePeriod, d.aborted)
+	if minDrain > 0 {
+		d.minElapsed = d.clock.After(minDrain)
+	}
+	if hardDeadline > 0 {
+		d.hardExpired = d.clock.After(hardDeadline)
+	}
+	return d, nil
+}
+
+// endStop releases what `beginDrain` set up and marks the Watcher done.
+func (w *Watcher) endStop(d *drainState) {
+	d.stopServers()
+	close(d.finished)
+	if d.coordinator != nil {
+		// Released before Done, after which the process may exit.
+		d.coordinator.ReleaseDrainSlot()
+	}
+	w.mu.Lock()
+	w.cancelChan = nil
+	w.stopDone = nil
+	w.graceDeadline = time.Time{}
+	w.markDoneLocked()
+	w.stopExpiryLocked()
+	w.mu.Unlock()
+	// Last so Close only returns once everything above has unwound.
+	close(d.stopDone)
+}
+
+// waitForDrain waits for the drain to complete, for the minimum drain duration and
+// for the managed servers to stop, or until the grace period or hard deadline
+// expires or the drain is cancelled. The returned error records which.
+func (w *Watcher) waitForDrain(d *drainState) *ShutdownError {
+	shutdownErr := new(ShutdownError)
+	select {
+	case <-d.drained:
+	case <-d.graceExpired:
+		shutdownErr.TimedOut = true
+	case <-d.hardExpired:
+		shutdownErr.TimedOut = true
+		shutdownErr.HardDeadline = true
+	case <-d.cancelChan:
+		shutdownErr.Cancelled = true
+	case <-d.closeChan:
+		shutdownErr.Cancelled = true
+	case <-d.cfg.cancel:
+		shutdownErr.Cancelled = true
+	}
+	if d.minElapsed != nil && !shutdownErr.TimedOut && !shutdownErr.Cancelled {
+		// The conns have drained, so the grace period expiring here is not a timeout.
+		select {
+		case <-d.minElapsed:
+		case <-d.graceExpired:
+		case <-d.hardExpired:
+		case <-d.cancelChan:
+			shutdownErr.Cancelled = true
+		case <-d.closeChan:
+			shutdownErr.Cancelled = true
+		case <-d.cfg.cancel:
+			shutdownErr.Cancelled = true
+		}
+	}
+	// Managed servers must be fully stopped before the hooks run. Check them first,
+	// as the grace period may already have expired during the minimum drain.
+	if !shutdownErr.TimedOut && !shutdownErr.Cancelled {
+		select {
+		case <-d.serversStopped:
+		default:
+			select {
+			case <-d.serversStopped:
+			case <-d.graceExpired:
+				shutdownErr.TimedOut = true
+			case <-d.hardExpired:
+				shutdownErr.TimedOut = true
+				shutdownErr.HardDeadline = true
+			case <-d.cancelChan:
+				shutdownErr.Cancelled = true
+			case <-d.closeChan:
+				shutdownErr.Cancelled = true
+			case <-d.cfg.cancel:
+				shutdownErr.Cancelled = true
+			}
+		}
+	}
+	return shutdownErr
+}
+
+// finishDrain ends the drain: it force-closes managed servers that have not
+// stopped, records the outcome, gives up on abandoned hijacked conns after a timeout
+// and collects the children's errors.
+func (w *Watcher) finishDrain(d *drainState, shutdownErr *ShutdownError) {
+	w.endDrainUpdates()
+	d.stopServers()
+	<-d.serversStopped
+	drainTime := d.clock.Now().Sub(d.start)
+	w.mu.Lock()
+	w.lastTimedOut = shutdownErr.TimedOut
+	w.lastDrain = drainTime
+	abandoned := int64(0)
+	if shutdownErr.TimedOut {
+		abandoned = w.abandonHijackedLocked()
+	}
+	w.unlockAndNotify()
+	if abandoned > 0 {
+		w.logf("OnStop: gave up on %d abandoned hijacked connections", abandoned)
+	}
+	if !shutdownErr.HardDeadline {
+		// Children are bounded by the same grace period, but not the hard deadline.
+		shutdownErr.ChildErrors = <-d.childrenStopped
+	}
+	if d.cfg.drainTime != nil {
+		*d.cfg.drainTime = drainTime
+	}
+	shutdownErr.RemainingConns = w.ActiveConns()
+}
+
+// drainedConns returns how many of the conns open when the drain began have closed.
+func (d *drainState) drainedConns(shutdownErr *ShutdownError) int64 {
+	drained := d.startConns - shutdownErr.RemainingConns
+	if drained < 0 {
+		// More connections arrived during the drain than closed.
+		return 0
+	}
+	return drained
+}
+
+// runStopHooks runs the critical hooks, then the shutdown hooks and final hooks
+// unless the hard deadline passed, adding their errors to shutdownErr. It returns
+// the results of every hook run by this shutdown, the pre-drain hooks first.
+func (w *Watcher) runStopHooks(d *drainState, shutdownErr *ShutdownError, preResults []HookResult) []HookResult {
+	if d.cfg.skipHooks {
+		return nil
+	}
+	criticalResults := w.runCriticalHooks(d.cfg.ctx)
+	var results []HookResult
+	var budgetErr error
+	if !shutdownErr.HardDeadline {
+		results, budgetErr = w.runHooks(d.cfg.ctx, d.graceExpired)
+	}
+	if len(preResults) != 0 || len(criticalResults) != 0 {
+		results = append(append(preResults, criticalResults...), results...)
+		w.setHookResults(results)
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			shutdownErr.HookErrors = append(shutdownErr.HookErrors, result.Err)
+		}
+	}
+	if budgetErr != nil {
+		shutdownErr.HookErrors = append(shutdownErr.HookErrors, budgetErr)
+	}
+	if shutdownErr.HardDeadline {
+		return results
+	}
+	finalResults := w.runFinalHooks(d.cfg.ctx, ShutdownStats{
+		Duration:       d.clock.Now().Sub(d.start),
+		StartConns:     d.startConns,
+		DrainedConns:   d.drainedConns(shutdownErr),
+		RemainingConns: shutdownErr.RemainingConns,
+		TimedOut:       shutdownErr.TimedOut,
+		Cancelled:      shutdownErr.Cancelled,
+		HookErrors:     append([]error(nil), shutdownErr.HookErrors...),
+	})
+	for _, result := range finalResults {
+		if result.Err != nil {
+			shutdownErr.HookErrors = append(shutdownErr.HookErrors, result.Err)
+		}
+	}
+	if len(finalResults) != 0 {
+		results = append(results, finalResults...)
+		w.setHookResults(results)
+	}
+	return results
+}
+
+// reportShutdown logs a summary of the shutdown and writes its diagnostics record.
+func (w *Watcher) reportShutdown(d *drainState, shutdownErr *ShutdownError, results []HookResult) {
+	elapsed, drainedConns := d.clock.Now().Sub(d.start), d.drainedConns(shutdownErr)
+	w.logf("OnStop: shutdown summary duration=%v drained=%d force_closed=%d hooks_run=%d hooks_failed=%d timed_out=%t",
+		elapsed, drainedConns, shutdownErr.RemainingConns,
+		len(results), len(shutdownErr.HookErrors), shutdownErr.TimedOut)
+	rec := ShutdownRecord{
+		Time:           d.start,
+		DurationMS:     elapsed.Milliseconds(),
+		StartConns:     d.startConns,
+		DrainedConns:   drainedConns,
+		RemainingConns: shutdownErr.RemainingConns,
+		Hooks:          make([]HookRecord, len(results)),
+		Outcome:        shutdownOutcome(shutdownErr),
+	}
+	for i, result := range results {
+		rec.Hooks[i] = HookRecord{Name: result.Name, DurationMS: result.Duration.Milliseconds()}
+		if result.Err != nil {
+			rec.Hooks[i].Err = result.Err.Error()
+		}
+	}
+	w.writeDiagnostics(rec)
+}
@@ -0,0 +1,60 @@
+package httpdshutdown
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// legalTransitions lists the states `net/http` may move a connection to from each
+// state. Closed and Hijacked are terminal.
+var legalTransitions = map[http.ConnState][]http.ConnState{
+	http.StateNew:    {http.StateActive, http.StateClosed},
+	http.StateActive: {http.StateIdle, http.StateHijacked, http.StateClosed},
+	http.StateIdle:   {http.StateActive, http.StateClosed},
+}
+
+// WithStrictStateValidation makes `RecordConnStateConn` check that each connection
+// moves through its states in the order `net/http` does, for instance that it never
+// becomes Active again after it was Closed. Illegal transitions are logged through
+// the logger set with `WithLogger` and reported by `Validate`, which helps diagnose
+// misbehaving middleware or custom transports. It is off by default, and has no
+// effect on `RecordConnState`, which does not see individual connections.
+func WithStrictStateValidation(enabled bool) Option {
+	return func(w *Watcher) error {
+		w.strictStates = enabled
+		return nil
+	}
+}
+
+// checkTransitionLocked records a violation if newState cannot follow the state last
+// recorded for c. A connection that was closed is no longer tracked, so any state
+// but New for an untracked connection is also a violation. w.mu must be held.
+func (w *Watcher) checkTransitionLocked(c net.Conn, newState http.ConnState) {
+	info, ok := w.conns[c]
+	tracked := ok && info.stateSeen
+	switch {
+	case newState == http.StateNew && !tracked:
+		return
+	case newState == http.StateNew:
+		w.stateViolationLocked(fmt.Sprintf("%s: %v -> %v", info.id, info.state, newState))
+	case !tracked:
+		w.stateViolationLocked(fmt.Sprintf("%s: %v for an untracked connection", c.RemoteAddr(), newState))
+	default:
+		for _, legal := range legalTransitions[info.state] {
+			if newState == legal {
+				return
+			}
+		}
+		w.stateViolationLocked(fmt.Sprintf("%s: %v -> %v", info.id, info.state, newState))
+	}
+}
+
+// stateViolationLocked counts and logs an illegal transition. w.mu must be held.
+func (w *Watcher) stateViolationLocked(desc string) {
+	w.stateViolations++
+	w.lastViolation = desc
+	if w.logger != nil {
+		w.logger.Printf("RecordConnStateConn: illegal state transition %s", desc)
+	}
+}
@@ -0,0 +1,62 @@
+package httpdshutdown
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStrictStateValidation(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestStrictStateValidation: should not be nil")
+	}
+	buf := new(bytes.Buffer)
+	if err := w.Configure(WithLogger(log.New(buf, "", 0)), WithStrictStateValidation(true)); err != nil {
+		t.Fatalf("TestStrictStateValidation: should configure, got %v", err)
+	}
+	c, peer := net.Pipe()
+	defer c.Close()
+	defer peer.Close()
+
+	for _, state := range []http.ConnState{http.StateNew, http.StateActive, http.StateIdle, http.StateActive, http.StateClosed} {
+		w.RecordConnStateConn(c, state)
+	}
+	if err := w.Validate(); err != nil {
+		t.Errorf("TestStrictStateValidation: should accept a legal sequence, got %v", err)
+	}
+
+	w.RecordConnStateConn(c, http.StateActive) // after Closed
+	err := w.Validate()
+	if err == nil || !strings.Contains(err.Error(), "1 illegal connection state transitions") {
+		t.Errorf("TestStrictStateValidation: should report Closed -> Active, got %v", err)
+	}
+	if !strings.Contains(buf.String(), "illegal state transition") {
+		t.Errorf("TestStrictStateValidation: should log the violation, got %q", buf.String())
+	}
+
+	if err := w.Reset(); err != nil {
+		t.Fatalf("TestStrictStateValidation: should reset, got %v", err)
+	}
+	w.RecordConnStateConn(c, http.StateNew)
+	w.RecordConnStateConn(c, http.StateIdle) // New -> Idle
+	err = w.Validate()
+	if err == nil || !strings.Contains(err.Error(), "new -> idle") {
+		t.Errorf("TestStrictStateValidation: should report New -> Idle, got %v", err)
+	}
+}
+
+func TestStrictStateValidationOff(t *testing.T) {
+	w, _ := NewWatcher(3000)
+	c, peer := net.Pipe()
+	defer c.Close()
+	defer peer.Close()
+	w.RecordConnStateConn(c, http.StateNew)
+	w.RecordConnStateConn(c, http.StateIdle)
+	if err := w.Validate(); err != nil {
+		t.Errorf("TestStrictStateValidationOff: should not check transitions by default, got %v", err)
+	}
+}
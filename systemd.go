@@ -0,0 +1,52 @@
+package httpdshutdown
+
+import (
+	"net"
+	"os"
+)
+
+// WithSystemdNotify makes the Watcher notify systemd of the daemon's state when it
+// runs as a `Type=notify` service: `READY=1` is sent when `SetReady(true)` is called
+// and `STOPPING=1` when `OnStop` begins, after which systemd's `TimeoutStopSec`
+// bounds the shutdown. Nothing is sent unless the `NOTIFY_SOCKET` environment
+// variable is set, so it is safe to enable outside systemd.
+func WithSystemdNotify(notify bool) Option {
+	return func(w *Watcher) error {
+		w.systemdNotify = notify
+		return nil
+	}
+}
+
+// notifySystemd sends state to the socket named by `NOTIFY_SOCKET`, if notification
+// is enabled. Failures are logged, as there is nothing else to be done about them.
+func (w *Watcher) notifySystemd(state string) {
+	w.mu.Lock()
+	notify := w.systemdNotify
+	w.mu.Unlock()
+	if !notify {
+		return
+	}
+	err := sdNotify(os.Getenv("NOTIFY_SOCKET"), state)
+	if err != nil {
+		w.logf("notifySystemd: could not send %s: %v", state, err)
+	}
+}
+
+// sdNotify implements the datagram protocol of sd_notify(3). An empty socket name
+// means the daemon is not running under systemd, which is not an error.
+func sdNotify(socket, state string) error {
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		// A socket in the abstract namespace.
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,53 @@
+package httpdshutdown
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSystemdNotify(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("TestSystemdNotify: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+	read := func() string {
+		buf := make([]byte, 64)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return ""
+		}
+		return string(buf[:n])
+	}
+
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestSystemdNotify: should not be nil")
+	}
+	err = w.Configure(WithSystemdNotify(true))
+	if err != nil {
+		t.Fatalf("TestSystemdNotify: should not have error")
+	}
+	w.SetReady(true)
+	if msg := read(); msg != "READY=1" {
+		t.Errorf("TestSystemdNotify: should send READY=1, got %q", msg)
+	}
+	err = w.OnStop()
+	if err != nil {
+		t.Errorf("TestSystemdNotify: should not have error: %v", err)
+	}
+	if msg := read(); msg != "STOPPING=1" {
+		t.Errorf("TestSystemdNotify: should send STOPPING=1, got %q", msg)
+	}
+}
+
+func TestSystemdNotifyUnset(t *testing.T) {
+	if sdNotify("", "READY=1") != nil {
+		t.Errorf("TestSystemdNotifyUnset: should not have error without a socket")
+	}
+}
@@ -0,0 +1,79 @@
+package httpdshutdown
+
+import (
+	"net"
+	"net/http"
+)
+
+// TriggerShutdown starts `OnStop` in a background goroutine and returns immediately,
+// so shutdown can be initiated from code that must not block on it. It returns a
+// channel that receives the result of `OnStop`, or an error at once on a nil
+// Watcher. From inside a request handler use `TriggerShutdownRequest` instead, so the
+// request's own connection does not hold up the drain.
+//
+// Example use:
+//
+//	go func() {
+//	        <-quit
+//	        watcher.TriggerShutdown()
+//	}()
+func (w *Watcher) TriggerShutdown() <-chan error {
+	if w == nil {
+		return nilReceiverResult("TriggerShutdown")
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- w.OnStop()
+	}()
+	return result
+}
+
+// TriggerShutdownRequest is `TriggerShutdown` for a handler serving r, such as an
+// admin endpoint. Calling `OnStop` directly from a handler would block it until the
+// grace period runs out, as the drain waits for the very request, and connection,
+// that is calling it. The connection r arrived on is also closed as soon as the
+// response has been written, rather than being kept alive, so a keep-alive client
+// does not hold the drain open for the whole grace period. This needs the connection to have been tagged by `ConnContext` and
+// recorded with `RecordConnStateConn`, as on servers built by `NewManagedServer`.
+//
+// Example use:
+//
+//	mux.HandleFunc("/shutdown", func(rw http.ResponseWriter, r *http.Request) {
+//	        watcher.TriggerShutdownRequest(r)
+//	        rw.WriteHeader(http.StatusAccepted)
+//	})
+func (w *Watcher) TriggerShutdownRequest(r *http.Request) <-chan error {
+	if w == nil {
+		return nilReceiverResult("TriggerShutdownRequest")
+	}
+	if c, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+		w.mu.Lock()
+		if info, ok := w.conns[c]; ok {
+			info.closeIdle = true
+			w.conns[c] = info
+		}
+		w.mu.Unlock()
+	}
+	return w.TriggerShutdown()
+}
+
+// ShutdownHandler returns a handler that starts a graceful shutdown with
+// `TriggerShutdownRequest` and responds with 202 Accepted. The response also asks
+// the client to close the connection, which covers connections that are not tagged
+// by `ConnContext`.
+//
+// Example use:
+//
+//	mux.Handle("/shutdown", watcher.ShutdownHandler())
+func (w *Watcher) ShutdownHandler() http.Handler {
+	if w == nil {
+		// we panic here instead of returning nil as the handler would otherwise
+		// fail on first request
+		panic("ShutdownHandler: receiver is nil")
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Connection", "close")
+		w.TriggerShutdownRequest(r)
+		rw.WriteHeader(http.StatusAccepted)
+	})
+}
@@ -0,0 +1,101 @@
+package httpdshutdown
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTriggerShutdown(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestTriggerShutdown: should not be nil")
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/shutdown", w.ShutdownHandler())
+	ts := httptest.NewUnstartedServer(w.TrackHandler(mux))
+	ts.Config.ConnState = func(conn net.Conn, newState http.ConnState) {
+		w.RecordConnState(newState)
+	}
+	ts.Start()
+	defer ts.Close()
+
+	start := time.Now()
+	resp, err := http.Get(ts.URL + "/shutdown")
+	if err != nil {
+		t.Fatalf("TestTriggerShutdown: should respond, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("TestTriggerShutdown: should respond 202, got %d", resp.StatusCode)
+	}
+	select {
+	case <-w.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("TestTriggerShutdown: should not deadlock on its own connection")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("TestTriggerShutdown: should not wait for the grace period, took %v", elapsed)
+	}
+	if w.LastShutdownTimedOut() {
+		t.Errorf("TestTriggerShutdown: should drain without timing out")
+	}
+}
+
+func TestTriggerShutdownResult(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestTriggerShutdownResult: should not be nil")
+	}
+	select {
+	case err := <-w.TriggerShutdown():
+		if err != nil {
+			t.Errorf("TestTriggerShutdownResult: should stop cleanly, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("TestTriggerShutdownResult: should deliver the OnStop result")
+	}
+}
+
+func TestTriggerShutdownRequestKeepAlive(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestTriggerShutdownRequestKeepAlive: should not be nil")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shutdown", func(rw http.ResponseWriter, r *http.Request) {
+		// No Connection: close, so only the Watcher closes the conn.
+		w.TriggerShutdownRequest(r)
+		rw.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewUnstartedServer(w.TrackHandler(mux))
+	ts.Config.ConnState = w.RecordConnStateConn
+	ts.Config.ConnContext = w.ConnContext
+	ts.Start()
+	defer ts.Close()
+
+	client := &http.Client{Transport: &http.Transport{}} // keeps conns alive
+	defer client.CloseIdleConnections()
+	start := time.Now()
+	resp, err := client.Get(ts.URL + "/shutdown")
+	if err != nil {
+		t.Fatalf("TestTriggerShutdownRequestKeepAlive: should respond, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.Close {
+		t.Fatalf("TestTriggerShutdownRequestKeepAlive: response should allow keep-alive")
+	}
+	select {
+	case <-w.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("TestTriggerShutdownRequestKeepAlive: should not wait on the admin conn")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("TestTriggerShutdownRequestKeepAlive: should not wait for the grace period, took %v", elapsed)
+	}
+	if w.LastShutdownTimedOut() {
+		t.Errorf("TestTriggerShutdownRequestKeepAlive: should drain without timing out")
+	}
+}
@@ -0,0 +1,46 @@
+package httpdshutdown
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Validate checks the Watcher's internal invariants and returns an error describing
+// every one that is violated, or nil. It is meant for tests and assertions, where it
+// catches wiring mistakes such as a `ConnState` callback that records closes for
+// connections it never recorded as new. Such closes leave the count at zero rather
+// than making it negative, so without Validate they go unnoticed.
+//
+// Example use:
+//
+//	if err := watcher.Validate(); err != nil {
+//	        t.Fatal(err)
+//	}
+func (w *Watcher) Validate() error {
+	if w == nil {
+		return errors.New("Validate: receiver is nil")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var errs []error
+	activeConns, peakConns := w.activeConns.Load(), w.peakConns.Load()
+	if w.unbalancedCloses > 0 {
+		errs = append(errs, fmt.Errorf("Validate: %d connection closes were recorded with no connection open", w.unbalancedCloses))
+	}
+	if w.stateViolations > 0 {
+		errs = append(errs, fmt.Errorf("Validate: %d illegal connection state transitions, last: %s", w.stateViolations, w.lastViolation))
+	}
+	if activeConns < 0 || w.inFlight < 0 || w.workers < 0 {
+		errs = append(errs, fmt.Errorf("Validate: negative count: conns=%d in_flight=%d workers=%d", activeConns, w.inFlight, w.workers))
+	}
+	if w.tlsConns < 0 || w.tlsConns > activeConns {
+		errs = append(errs, fmt.Errorf("Validate: %d TLS connections out of %d open", w.tlsConns, activeConns))
+	}
+	if peakConns < activeConns {
+		errs = append(errs, fmt.Errorf("Validate: peak of %d connections is below the %d open", peakConns, activeConns))
+	}
+	if w.accepting && w.shuttingDown {
+		errs = append(errs, errors.New("Validate: accepting connections while shutting down"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,50 @@
+package httpdshutdown
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestValidate: should not be nil")
+	}
+	if err := w.Validate(); err != nil {
+		t.Errorf("TestValidate: new Watcher should be valid: %v", err)
+	}
+	w.Accepting(true)
+	w.RecordConnState(http.StateNew)
+	w.RecordConnState(http.StateClosed)
+	if err := w.Validate(); err != nil {
+		t.Errorf("TestValidate: balanced calls should be valid: %v", err)
+	}
+
+	// A close with no matching new.
+	w.RecordConnState(http.StateClosed)
+	err := w.Validate()
+	if err == nil || !strings.Contains(err.Error(), "1 connection closes") {
+		t.Errorf("TestValidate: should catch the underflow, got %v", err)
+	}
+	if w.ActiveConns() != 0 {
+		t.Errorf("TestValidate: count should stay at zero")
+	}
+	_ = w.Reset()
+	if err := w.Validate(); err != nil {
+		t.Errorf("TestValidate: Reset should clear the underflow: %v", err)
+	}
+}
+
+func TestValidateAcceptingWhileShuttingDown(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestValidateAcceptingWhileShuttingDown: should not be nil")
+	}
+	_ = w.OnStop()
+	w.Accepting(true)
+	err := w.Validate()
+	if err == nil || !strings.Contains(err.Error(), "shutting down") {
+		t.Errorf("TestValidateAcceptingWhileShuttingDown: should catch the flags, got %v", err)
+	}
+}
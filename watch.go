@@ -0,0 +1,69 @@
+package httpdshutdown
+
+import "context"
+
+// WatchContext shuts the daemon down with `OnStop` once ctx is done, for daemons
+// whose lifecycle is driven by a root context rather than signals. It returns a
+// channel that receives the result of `OnStop`. If the Watcher is closed first, the
+// channel is closed without a result. On a nil Watcher the channel receives an
+// error at once.
+//
+// Hooks are passed a context carrying ctx's values but not its cancellation, as ctx
+// is already done by the time they run.
+//
+// Example use:
+//
+//	done := watcher.WatchContext(ctx)
+//	go srv.ListenAndServe()
+//	err := <-done
+func (w *Watcher) WatchContext(ctx context.Context) <-chan error {
+	if w == nil {
+		return nilReceiverResult("WatchContext")
+	}
+	w.mu.Lock()
+	closed := w.closedChanLocked()
+	w.mu.Unlock()
+	result := make(chan error, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			result <- w.OnStopContext(context.WithoutCancel(ctx))
+		case <-closed:
+			close(result)
+		}
+	}()
+	return result
+}
+
+// Handoff coordinates a zero-downtime restart, where a new process shares the
+// listener, for instance with SO_REUSEPORT. Once the new instance signals it is
+// serving by sending on or closing ready, this Watcher stops accepting and drains
+// with `OnStop`, leaving new connections to the new instance. It returns a channel
+// that receives the result of `OnStop`. If the Watcher is closed first, the channel
+// is closed without a result. On a nil Watcher the channel receives an error at
+// once.
+//
+// Example use:
+//
+//	ready := make(chan struct{})
+//	go listenForSuccessor(ready) // closes ready once the new process is up
+//	err := <-watcher.Handoff(ready)
+func (w *Watcher) Handoff(ready <-chan struct{}) <-chan error {
+	if w == nil {
+		return nilReceiverResult("Handoff")
+	}
+	w.mu.Lock()
+	closed := w.closedChanLocked()
+	w.mu.Unlock()
+	result := make(chan error, 1)
+	go func() {
+		select {
+		case <-ready:
+			w.logf("Handoff: new instance is ready, draining")
+			result <- w.OnStop()
+		case <-closed:
+			close(result)
+		}
+	}()
+	return result
+}
@@ -0,0 +1,95 @@
+package httpdshutdown
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWatchContext(t *testing.T) {
+	hookCtx := make(chan context.Context, 1)
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestWatchContext: should not be nil")
+	}
+	_ = w.AddContextHook(func(ctx context.Context) error {
+		hookCtx <- ctx
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), testContextKey("root"), "root"))
+	done := w.WatchContext(ctx)
+	select {
+	case <-done:
+		t.Fatalf("TestWatchContext: should not stop before ctx is done")
+	case <-time.After(10 * time.Millisecond):
+	}
+	cancel()
+	err := <-done
+	if err != nil {
+		t.Errorf("TestWatchContext: should not have error: %v", err)
+	}
+	if !w.IsShuttingDown() {
+		t.Errorf("TestWatchContext: should be shutting down")
+	}
+	select {
+	case got := <-hookCtx:
+		if got.Value(testContextKey("root")) != "root" || got.Err() != nil {
+			t.Errorf("TestWatchContext: hook should get ctx's values without its cancellation")
+		}
+	default:
+		t.Errorf("TestWatchContext: hook should have run")
+	}
+}
+
+func TestWatchContextClose(t *testing.T) {
+	w, wErr := NewWatcher(1000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestWatchContextClose: should not be nil")
+	}
+	done := w.WatchContext(context.Background())
+	_ = w.Close()
+	if _, ok := <-done; ok {
+		t.Errorf("TestWatchContextClose: should not shut down when closed")
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	old, oldErr := NewWatcher(1000)
+	if old == nil || oldErr != nil {
+		t.Fatalf("TestHandoff: should not be nil")
+	}
+	old.Accepting(true)
+	old.RecordConn(true)
+	ready := make(chan struct{})
+	done := old.Handoff(ready)
+
+	// The new instance comes up on the shared listener.
+	successor, successorErr := NewWatcher(1000)
+	if successor == nil || successorErr != nil {
+		t.Fatalf("TestHandoff: should not be nil")
+	}
+	successor.Accepting(true)
+	if !old.IsAccepting() {
+		t.Errorf("TestHandoff: old instance should accept until the new one is ready")
+	}
+	close(ready)
+	for old.IsAccepting() {
+		time.Sleep(time.Millisecond)
+	}
+	if !old.IsShuttingDown() {
+		t.Errorf("TestHandoff: old instance should be draining")
+	}
+	select {
+	case <-done:
+		t.Fatalf("TestHandoff: old instance should wait for its conn")
+	case <-time.After(10 * time.Millisecond):
+	}
+	old.RecordConn(false)
+	err := <-done
+	if err != nil {
+		t.Errorf("TestHandoff: should not have error: %v", err)
+	}
+	if !successor.IsAccepting() {
+		t.Errorf("TestHandoff: new instance should keep accepting")
+	}
+}
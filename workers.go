@@ -0,0 +1,51 @@
+package httpdshutdown
+
+// WorkerStarted counts a background worker, such as a job queue consumer, that
+// `OnStop` must wait for along with connections and requests. Every call must be
+// matched by a call to `WorkerFinished`. Workers should watch `IsShuttingDown` or
+// `Done` so they stop picking up new work once shutdown begins.
+//
+// Example use:
+//
+//	watcher.WorkerStarted()
+//	go func() {
+//	        defer watcher.WorkerFinished()
+//	        for !watcher.IsShuttingDown() {
+//	                processNextJob()
+//	        }
+//	}()
+func (w *Watcher) WorkerStarted() {
+	if w == nil {
+		nilReceiver("WorkerStarted")
+		return
+	}
+	w.mu.Lock()
+	w.workers++
+	w.mu.Unlock()
+}
+
+// WorkerFinished counts a worker counted by `WorkerStarted` as finished. Unbalanced
+// calls leave the count at zero rather than letting it go negative.
+func (w *Watcher) WorkerFinished() {
+	if w == nil {
+		nilReceiver("WorkerFinished")
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.workers == 0 {
+		return
+	}
+	w.workers--
+	w.connsCond.Broadcast()
+}
+
+// ActiveWorkers returns the number of workers started but not yet finished.
+func (w *Watcher) ActiveWorkers() int64 {
+	if w == nil {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.workers
+}
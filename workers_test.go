@@ -0,0 +1,41 @@
+package httpdshutdown
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorkers(t *testing.T) {
+	w, wErr := NewWatcher(3000)
+	if w == nil || wErr != nil {
+		t.Fatalf("TestWorkers: should not be nil")
+	}
+	const n = 3
+	finished := make(chan int, n)
+	for i := 0; i < n; i++ {
+		w.WorkerStarted()
+		go func(i int) {
+			defer w.WorkerFinished()
+			for !w.IsShuttingDown() {
+				time.Sleep(time.Millisecond)
+			}
+			// Finish the job in hand.
+			time.Sleep(time.Duration(i+1) * 20 * time.Millisecond)
+			finished <- i
+		}(i)
+	}
+	if w.ActiveWorkers() != n {
+		t.Errorf("TestWorkers: should have %d workers, got %d", n, w.ActiveWorkers())
+	}
+	err := w.OnStop()
+	if err != nil {
+		t.Errorf("TestWorkers: should not have error: %v", err)
+	}
+	if len(finished) != n || w.ActiveWorkers() != 0 {
+		t.Errorf("TestWorkers: OnStop should wait for all workers, %d finished", len(finished))
+	}
+	w.WorkerFinished()
+	if w.ActiveWorkers() != 0 {
+		t.Errorf("TestWorkers: count should stay at zero, got %d", w.ActiveWorkers())
+	}
+}